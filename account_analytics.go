@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const accountsPerPage = 50
+
+// accountDatasets lists the datasets scoped to a Cloudflare account rather
+// than a zone, to scrape. Their metrics are labelled with the Cloudflare
+// account's name as "cloudflare_account". Cloudflare accounts are only listed
+// if there are any. As for zoneDatasets, the caller must hold the scrape lock.
+func (e *exporter) accountDatasets(account *account) []analyticsDataset {
+	var datasets []analyticsDataset
+	for _, dataset := range []analyticsDataset{
+		{
+			"workersInvocationsAdaptive", "graphql:accounts:workersInvocationsAdaptive", workersInvocationsGqlReq,
+			workersInvocationsGqlSelection, extractAccountWorkersInvocations,
+			account.lastSeen("workersInvocationsAdaptive"),
+		},
+	} {
+		if e.datasetEnabled(dataset.name) {
+			datasets = append(datasets, dataset)
+		}
+	}
+	return datasets
+}
+
+// getAccountAnalytics queries every account-scoped dataset for every
+// Cloudflare account. As with getZoneAnalytics, failures are recorded per
+// Cloudflare account and dataset and the remaining queries still made.
+func (e *exporter) getAccountAnalytics(ctx context.Context, account *account) error {
+	e.scrapeLock.Lock()
+	cloudflareAccounts := account.cloudflareAccounts
+	queries := datasetQueries(e.accountDatasets(account), cloudflareAccounts)
+	e.scrapeLock.Unlock()
+	var errs scrapeErrors
+	for i, query := range queries {
+		err := e.getAccountAnalyticsKind(ctx, account, query.dataset, query.tag, cloudflareAccounts[query.tag])
+		if errors.Is(err, errQueryBudgetExhausted) {
+			level.Info(e.logger).Log(
+				"msg", "GraphQL query budget exhausted, deferring queries to the next scrape",
+				"account", account.name, "deferred", len(queries)-i,
+			)
+			graphqlQueriesDeferred.WithLabelValues(account.name).Add(float64(len(queries) - i))
+			break
+		}
+		if err != nil {
+			errs.add(fmt.Errorf(
+				"cloudflare account %s: %s: %w", cloudflareAccounts[query.tag], query.dataset.name, err,
+			))
+			if endsAnalytics(ctx, err) {
+				break
+			}
+		}
+	}
+	return errs.err()
+}
+
+// getAccountAnalyticsKind queries a dataset for a Cloudflare account, paging
+// through its results until they are up to date, and records the outcome.
+func (e *exporter) getAccountAnalyticsKind(
+	ctx context.Context, account *account, dataset analyticsDataset, cloudflareAccountID, cloudflareAccountName string,
+) error {
+	for overlap := true; ; overlap = false {
+		if err := account.queryBudget.take(ctx); err != nil {
+			return err
+		}
+		e.scrapeLock.Lock()
+		lastDateTimesCounted, startTime := e.queryStartTime(
+			dataset.lastSeenBucketTimes, []string{cloudflareAccountID}, overlap,
+		)
+		e.scrapeLock.Unlock()
+		dataset.req.Var("account", cloudflareAccountID)
+		dataset.req.Var("start_time", startTime)
+		level.Debug(e.logger).Log(
+			"event", "get account analytics", "account", account.name, "cloudflare_account", cloudflareAccountName,
+			"request", dataset.requestKind, "msg", "starting", "start_time", startTime.String(),
+		)
+		var gqlResp cloudflareResp
+		err := e.makeGraphqlRequest(ctx, e.logger, dataset.requestKind, account, "", dataset.req, &gqlResp)
+		if err == nil && len(gqlResp.Viewer.Accounts) == 0 {
+			// The account should only be missing if it has become inaccessible
+			// since it was last listed.
+			err = fmt.Errorf("missing from response")
+		}
+		var results int
+		lastDateTimeCounted := lastDateTimesCounted[cloudflareAccountID]
+		latestDateTimeCounted := lastDateTimeCounted
+		if err == nil {
+			e.scrapeLock.Lock()
+			results, latestDateTimeCounted, err = dataset.extract(
+				account.name, gqlResp.Viewer.Accounts[0], cloudflareAccountName, lastDateTimeCounted,
+			)
+			if err == nil {
+				latestDateTimeCounted = updateLastSeen(dataset.lastSeenBucketTimes, cloudflareAccountID, latestDateTimeCounted)
+			}
+			e.scrapeLock.Unlock()
+		}
+		var more bool
+		if err == nil {
+			level.Debug(e.logger).Log(
+				"event", "get account analytics", "account", account.name, "cloudflare_account", cloudflareAccountName,
+				"request", dataset.requestKind, "msg", "finished",
+				"last_datetime_bucket", latestDateTimeCounted.String(), "results", results,
+			)
+			more, err = nextPage(results, lastDateTimeCounted, latestDateTimeCounted)
+		}
+		if errors.Is(err, errPagingStalled) {
+			e.resumePaging(
+				account, e.cloudflareAccountScope(), dataset, cloudflareAccountID, cloudflareAccountName,
+				lastDateTimeCounted, overlap,
+			)
+			more, err = true, nil
+		}
+		if err != nil {
+			recordScrape(account, e.cloudflareAccountScope(), cloudflareAccountName, dataset, err)
+			return err
+		}
+		if !more {
+			recordScrape(account, e.cloudflareAccountScope(), cloudflareAccountName, dataset, nil)
+			return nil
+		}
+	}
+}
+
+// getCloudflareAccounts lists the Cloudflare accounts that an account's
+// credentials can access, by ID.
+func (e *exporter) getCloudflareAccounts(ctx context.Context, account *account) (map[string]string, error) {
+	cloudflareAccounts := map[string]string{}
+	_, err := e.restClient.getPages(ctx, account, "rest:accounts", "/accounts", nil, accountsPerPage, func(result json.RawMessage) error {
+		pageAccounts, err := parseCloudflareAccounts(result)
+		if err != nil {
+			return err
+		}
+		for cloudflareAccountID, cloudflareAccountName := range pageAccounts {
+			cloudflareAccounts[cloudflareAccountID] = cloudflareAccountName
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloudflareAccounts, nil
+}
+
+func (e *exporter) refreshCloudflareAccounts(ctx context.Context, account *account) error {
+	ctx, cancel := context.WithTimeout(ctx, e.scrapeTimeout)
+	defer cancel()
+	cloudflareAccounts, err := e.getCloudflareAccounts(ctx, account)
+	if err != nil {
+		return err
+	}
+
+	e.scrapeLock.Lock()
+	defer e.scrapeLock.Unlock()
+	e.updateCloudflareAccounts(account, cloudflareAccounts)
+	return nil
+}
+
+// updateCloudflareAccounts replaces the Cloudflare accounts that an account's
+// credentials can access, as described by updateScope. The caller must hold
+// the scrape lock.
+func (e *exporter) updateCloudflareAccounts(account *account, cloudflareAccounts map[string]string) {
+	e.updateScope(account, e.cloudflareAccountScope(), account.cloudflareAccounts, cloudflareAccounts)
+	account.cloudflareAccounts = cloudflareAccounts
+}
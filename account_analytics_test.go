@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCloudflareAccounts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/accounts", r.URL.Path)
+		_, _ = w.Write([]byte(`{
+			"success": true,
+			"result": [{"id": "an-account-id", "name": "An Account"}, {"id": "another-account-id", "name": "Another Account"}],
+			"result_info": {"page": 1, "per_page": 50, "total_pages": 1, "count": 2, "total_count": 2}
+		}`))
+	}))
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	cfExporter := exporter{restClient: newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error"))}
+	cloudflareAccounts, err := cfExporter.getCloudflareAccounts(context.Background(), &account{name: "an-account"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"an-account-id": "An Account", "another-account-id": "Another Account"}, cloudflareAccounts)
+}
+
+func TestAccountAnalytics(t *testing.T) {
+	for _, testCase := range []struct {
+		name               string
+		apiRespFixturePath string
+		expectedErr        string
+	}{
+		{
+			name:               "extracts the account's results",
+			apiRespFixturePath: "account_resp.json",
+		},
+		{
+			name:               "account missing from response",
+			apiRespFixturePath: "empty_http_reqs_resp.json",
+			expectedErr:        "missing from response",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			registerMetrics(prometheus.NewPedanticRegistry())
+
+			lastBucketTime := time.Now().UTC().Add(-time.Minute).Truncate(time.Minute)
+			var extracted []string
+			dataset := analyticsDataset{
+				name:        "aDataset",
+				requestKind: "graphql:accounts:aDataset",
+				req:         newGraphqlRequest(accountGqlQuery("aDataset { count }")),
+				extract: func(account string, resp scopeResp, scopeName string, _ time.Time) (int, time.Time, error) {
+					extracted = append(extracted, account+" "+resp.AccountTag+" "+scopeName)
+					return 1, lastBucketTime, nil
+				},
+				lastSeenBucketTimes: map[string]time.Time{},
+			}
+			graphqlClient := newFakeGraphqlClient([]string{testCase.apiRespFixturePath})
+			cfExporter := exporter{
+				logger: newPromLogger("error"), graphqlClient: graphqlClient, scrapeInterval: time.Minute, scrapeLock: &sync.Mutex{},
+			}
+			account := &account{name: "an-account", cloudflareAccounts: map[string]string{"an-account-id": "An Account"}}
+
+			err := cfExporter.getAccountAnalyticsKind(context.Background(), account, dataset, "an-account-id", "An Account")
+			require.Len(t, graphqlClient.requests, 1)
+			assert.Equal(t, "an-account-id", graphqlClient.requests[0].vars["account"])
+			if testCase.expectedErr != "" {
+				require.NotNil(t, err)
+				assert.Equal(t, testCase.expectedErr, err.Error())
+				assert.Equal(t, 1.0, testutil.ToFloat64(
+					accountScrapeErrs.WithLabelValues("an-account", "An Account", "aDataset", "unknown"),
+				))
+				assert.Empty(t, dataset.lastSeenBucketTimes)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, []string{"an-account an-account-id An Account"}, extracted)
+			assert.Equal(t, lastBucketTime, dataset.lastSeenBucketTimes["an-account-id"])
+			assert.InDelta(t, float64(time.Now().Unix()), testutil.ToFloat64(
+				accountLastSuccessTimestampSeconds.WithLabelValues("an-account", "An Account", "aDataset"),
+			), 10)
+		})
+	}
+}
+
+func TestAccountAnalytics_WorkersInvocations(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	registerMetrics(reg)
+
+	graphqlClient := newFakeGraphqlClient([]string{"workers_invocations_resp.json"})
+	cfExporter := exporter{
+		scrapeLock:      &sync.Mutex{},
+		logger:          newPromLogger("error"),
+		graphqlClient:   graphqlClient,
+		enabledDatasets: map[string]bool{"workersInvocationsAdaptive": true},
+	}
+	account := &account{
+		name:               "an-account",
+		cloudflareAccounts: map[string]string{"an-account-id": "An Account"},
+		lastSeenBucketTimes: lastUpdatedTimes{
+			"workersInvocationsAdaptive": {"an-account-id": time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)},
+		},
+	}
+	require.Nil(t, cfExporter.getAccountAnalytics(context.Background(), account))
+	require.Len(t, graphqlClient.requests, 1)
+	assert.Contains(t, graphqlClient.requests[0].query, "accounts(filter: {accountTag: $account})")
+
+	// Every script and status in a minute is counted, but not the minute
+	// already counted.
+	fixture, err := os.Open(filepath.Join("testdata", "expected_workers_invocations.metrics"))
+	require.Nil(t, err)
+	defer fixture.Close()
+	err = testutil.GatherAndCompare(reg, fixture,
+		"cloudflare_workers_requests_total", "cloudflare_workers_errors_total", "cloudflare_workers_subrequests_total",
+		"cloudflare_workers_cpu_time_seconds", "cloudflare_workers_wall_time_seconds",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// workersInvocationsPage returns a page of Workers invocations, one request for
+// each minute bucket given.
+func workersInvocationsPage(t *testing.T, bucketTimes ...time.Time) scopeResp {
+	var groups []map[string]interface{}
+	for _, bucketTime := range bucketTimes {
+		groups = append(groups, map[string]interface{}{
+			"dimensions": map[string]string{
+				"datetimeMinute": bucketTime.Format(time.RFC3339), "scriptName": "paged-worker", "status": "success",
+			},
+			"sum": map[string]uint64{"requests": 1},
+		})
+	}
+	page, err := json.Marshal(map[string]interface{}{"workersInvocationsAdaptive": groups})
+	require.Nil(t, err)
+	var resp scopeResp
+	require.Nil(t, json.Unmarshal(page, &resp))
+	return resp
+}
+
+func TestExtractAccountWorkersInvocations_LeavesTruncatedMinuteForNextPage(t *testing.T) {
+	lastDateTimeCounted := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	firstMinute, truncatedMinute := lastDateTimeCounted.Add(time.Minute), lastDateTimeCounted.Add(2*time.Minute)
+	var bucketTimes []time.Time
+	for i := 0; i < apiMaxLimit; i++ {
+		if i < apiMaxLimit/2 {
+			bucketTimes = append(bucketTimes, firstMinute)
+		} else {
+			bucketTimes = append(bucketTimes, truncatedMinute)
+		}
+	}
+	requests := workersRequests.WithLabelValues("an-account", "Paged Account", "paged-worker", "success")
+
+	// The page is full, so its last minute may have been cut off: only the
+	// minutes before it are counted.
+	results, lastDateTimeCounted, err := extractAccountWorkersInvocations(
+		"an-account", workersInvocationsPage(t, bucketTimes...), "Paged Account", lastDateTimeCounted,
+	)
+	require.Nil(t, err)
+	assert.Equal(t, apiMaxLimit, results)
+	assert.Equal(t, firstMinute, lastDateTimeCounted)
+	assert.Equal(t, float64(apiMaxLimit/2), testutil.ToFloat64(requests))
+
+	// The next page carries on from the minute counted up to, and counts the
+	// whole of the minute that was cut off.
+	results, lastDateTimeCounted, err = extractAccountWorkersInvocations(
+		"an-account", workersInvocationsPage(t, truncatedMinute, truncatedMinute, truncatedMinute), "Paged Account",
+		lastDateTimeCounted,
+	)
+	require.Nil(t, err)
+	assert.Equal(t, 3, results)
+	assert.Equal(t, truncatedMinute, lastDateTimeCounted)
+	assert.Equal(t, float64(apiMaxLimit/2+3), testutil.ToFloat64(requests))
+}
+
+func TestQueryStartTime_OverlapsOnlyFirstPage(t *testing.T) {
+	cfExporter := exporter{}
+	lastSeen := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	lastSeenBucketTimes := map[string]time.Time{"an-account-id": lastSeen}
+
+	_, startTime := cfExporter.queryStartTime(lastSeenBucketTimes, []string{"an-account-id"}, true)
+	assert.Equal(t, lastSeen.Add(-5*time.Minute), startTime)
+	_, startTime = cfExporter.queryStartTime(lastSeenBucketTimes, []string{"an-account-id"}, false)
+	assert.Equal(t, lastSeen, startTime)
+}
+
+func TestUpdateCloudflareAccounts(t *testing.T) {
+	registerMetrics(prometheus.NewPedanticRegistry())
+	cfExporter := exporter{logger: newPromLogger("error")}
+	account := &account{name: "an-account"}
+
+	cfExporter.updateCloudflareAccounts(account, map[string]string{"an-account-id": "An Account"})
+	assert.Equal(t, 1.0, testutil.ToFloat64(cloudflareAccountsActive.WithLabelValues("an-account")))
+
+	cfExporter.updateCloudflareAccounts(account, map[string]string{"another-account-id": "Another Account"})
+	assert.Equal(t, map[string]string{"another-account-id": "Another Account"}, account.cloudflareAccounts)
+	assert.Equal(t, 1.0, testutil.ToFloat64(cloudflareAccountsActive.WithLabelValues("an-account")))
+}
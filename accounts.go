@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// account is a set of Cloudflare credentials, and the zones to scrape with
+// them. Its name is exposed as the "account" label on every zone metric.
+type account struct {
+	name        string
+	credentials credentials
+	zoneFilter  zoneFilter
+	queryBudget *queryBudget
+	// zones maps the IDs of the account's zones to their names. It is nil until
+	// the zones are first listed.
+	zones map[string]string
+	// cloudflareAccounts maps the IDs of the Cloudflare accounts the
+	// credentials can access to their names. It is nil until they are first
+	// listed, which only happens if account-scoped datasets are scraped.
+	cloudflareAccounts map[string]string
+	// lastSeenBucketTimes is the time each of the account's zones and
+	// Cloudflare accounts has been counted up to in each dataset. It is the
+	// account's own, as others may be able to access the same zones.
+	lastSeenBucketTimes lastUpdatedTimes
+}
+
+// lastSeen returns the times that a dataset has been counted up to for the
+// account, by the tag of the zone or Cloudflare account.
+func (a *account) lastSeen(dataset string) map[string]time.Time {
+	if a.lastSeenBucketTimes == nil {
+		a.lastSeenBucketTimes = lastUpdatedTimes{}
+	}
+	return a.lastSeenBucketTimes.dataset(dataset)
+}
+
+type accountsConfig struct {
+	Accounts []struct {
+		Name         string `json:"name"`
+		Email        string `json:"api_email"`
+		APIKey       string `json:"api_key"`
+		APIKeyFile   string `json:"api_key_file"`
+		APIToken     string `json:"api_token"`
+		APITokenFile string `json:"api_token_file"`
+		zoneFilterConfig
+	} `json:"accounts"`
+}
+
+func loadAccountsFile(path string) ([]*account, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseAccounts(f)
+}
+
+func parseAccounts(r io.Reader) ([]*account, error) {
+	var config accountsConfig
+	if err := json.NewDecoder(r).Decode(&config); err != nil {
+		return nil, err
+	}
+	if len(config.Accounts) == 0 {
+		return nil, fmt.Errorf("no accounts configured")
+	}
+
+	var accounts []*account
+	seen := map[string]bool{}
+	for i, accountConfig := range config.Accounts {
+		if accountConfig.Name == "" {
+			return nil, fmt.Errorf("account %d: name must be set", i)
+		}
+		if seen[accountConfig.Name] {
+			return nil, fmt.Errorf("account %s: name is not unique", accountConfig.Name)
+		}
+		seen[accountConfig.Name] = true
+
+		creds := credentials{
+			email:        accountConfig.Email,
+			apiKey:       accountConfig.APIKey,
+			apiKeyFile:   newCredentialFile(accountConfig.APIKeyFile),
+			apiToken:     accountConfig.APIToken,
+			apiTokenFile: newCredentialFile(accountConfig.APITokenFile),
+		}
+		if err := creds.validate(); err != nil {
+			return nil, fmt.Errorf("account %s: %w", accountConfig.Name, err)
+		}
+		zoneFilter, err := newZoneFilter(accountConfig.zoneFilterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", accountConfig.Name, err)
+		}
+		accounts = append(accounts, &account{
+			name: accountConfig.Name, credentials: creds, zoneFilter: zoneFilter,
+		})
+	}
+	return accounts, nil
+}
+
+func (a *account) setAuthHeaders(header http.Header) error {
+	reloaded, err := a.credentials.setHeaders(header)
+	if err != nil {
+		return fmt.Errorf("reading credentials: %w", err)
+	}
+	if reloaded {
+		credentialsLastReloadTimestampSeconds.WithLabelValues(a.name).SetToCurrentTime()
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAccountsFile(t *testing.T) {
+	accounts, err := loadAccountsFile("testdata/accounts_config.json")
+	require.Nil(t, err)
+	assert.Equal(t, []*account{
+		{
+			name:        "prod",
+			credentials: credentials{apiToken: "prod-token"},
+			zoneFilter:  zoneFilter{names: []string{"zone-1", "zone-2"}},
+		},
+		{
+			name:        "staging",
+			credentials: credentials{email: "staging@example.com", apiKey: "staging-key"},
+		},
+	}, accounts)
+}
+
+func TestParseAccounts_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "no accounts",
+			config: `{"accounts": []}`,
+		},
+		{
+			name:   "missing name",
+			config: `{"accounts": [{"api_token": "a-token"}]}`,
+		},
+		{
+			name:   "duplicate names",
+			config: `{"accounts": [{"name": "a", "api_token": "a-token"}, {"name": "a", "api_token": "b-token"}]}`,
+		},
+		{
+			name:   "missing credentials",
+			config: `{"accounts": [{"name": "a", "api_email": "a@example.com"}]}`,
+		},
+		{
+			name:   "invalid zone filter",
+			config: `{"accounts": [{"name": "a", "api_token": "a-token", "zone_plans": ["platinum"]}]}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseAccounts(strings.NewReader(tc.config))
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -2,17 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"github.com/machinebox/graphql"
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -25,25 +27,116 @@ const (
 	namespace     = "cloudflare"
 	apiMaxLimit   = 10000
 	maxTimeWindow = time.Hour
+	// The maximum page size of the zones REST API.
+	zonesPerPage = 50
 )
 
+// optionalDatasets are only scraped if enabled with --cloudflare-enable-datasets,
+// as they need further permissions, and cost queries for products that not
+// every account uses.
+var optionalDatasets = []string{
+	"loadBalancingRequestsAdaptiveGroups", "workersInvocationsAdaptive", "dnsAnalyticsAdaptiveGroups",
+	"httpRequestsAdaptiveCacheStatus", "httpRequestsAdaptiveLatency", "httpRequestsAdaptiveColos",
+}
+
+// companionDatasets are queried separately from, but scraped whenever, the
+// optional dataset they complete.
+var companionDatasets = map[string]string{
+	"dnsAnalyticsAdaptiveQueryNames": "dnsAnalyticsAdaptiveGroups",
+	"httpRequestsAdaptiveColo5xx":    "httpRequestsAdaptiveColos",
+}
+
 var (
 	// arguments
 	listenAddress = kingpin.Flag("listen-address", "Metrics exporter listen address.").
 			Short('l').Envar("CLOUDFLARE_EXPORTER_LISTEN_ADDRESS").Default(":9199").String()
-	cfEmail = kingpin.Flag("cloudflare-api-email", "email address for analytics API authentication.").
-		Envar("CLOUDFLARE_API_EMAIL").Required().String()
-	cfAPIKey = kingpin.Flag("cloudflare-api-key", "API key for analytics API authentication.").
-			Envar("CLOUDFLARE_API_KEY").Required().String()
+	cfEmail = kingpin.Flag("cloudflare-api-email", "email address for analytics API authentication. Requires --cloudflare-api-key.").
+		Envar("CLOUDFLARE_API_EMAIL").Default("").String()
+	cfAPIKey = kingpin.Flag("cloudflare-api-key", "API key for analytics API authentication. Requires --cloudflare-api-email.").
+			Envar("CLOUDFLARE_API_KEY").Default("").String()
+	cfAPIKeyFile = kingpin.Flag("cloudflare-api-key-file", "File containing the API key, re-read whenever it changes. Alternative to --cloudflare-api-key.").
+			Envar("CLOUDFLARE_API_KEY_FILE").Default("").String()
+	cfAPIToken = kingpin.Flag("cloudflare-api-token", "Scoped API token for analytics API authentication. Alternative to --cloudflare-api-email and --cloudflare-api-key.").
+			Envar("CLOUDFLARE_API_TOKEN").Default("").String()
+	cfAPITokenFile = kingpin.Flag("cloudflare-api-token-file", "File containing the API token, re-read whenever it changes. Alternative to --cloudflare-api-token.").
+			Envar("CLOUDFLARE_API_TOKEN_FILE").Default("").String()
 	cfZones = kingpin.Flag("cloudflare-zones", "Comma-separated list of zones to scrape. Omit to scrape all zones in account.").
 		Envar("CLOUDFLARE_ZONES").Default("").String()
+	zoneIDFilter = kingpin.Flag("zone-ids", "Comma-separated list of IDs of zones to scrape, in addition to those named by --cloudflare-zones.").
+			Envar("CLOUDFLARE_ZONE_IDS").Default("").String()
+	zoneIncludeRegex = kingpin.Flag("zone-include-regex", "Only scrape zones whose names match this regular expression.").
+				Envar("CLOUDFLARE_ZONE_INCLUDE_REGEX").Default("").String()
+	zoneExcludeRegex = kingpin.Flag("zone-exclude-regex", "Do not scrape zones whose names match this regular expression.").
+				Envar("CLOUDFLARE_ZONE_EXCLUDE_REGEX").Default("").String()
+	zonePlanFilter = kingpin.Flag("zone-plans", "Comma-separated list of plans (free, pro, business, enterprise) of zones to scrape. Omit to scrape zones on any plan.").
+			Envar("CLOUDFLARE_ZONE_PLANS").Default("").String()
+	zoneStatusFilter = kingpin.Flag("zone-statuses", "Comma-separated list of statuses (e.g. active, paused, pending) of zones to scrape. Omit to scrape all but pending zones.").
+				Envar("CLOUDFLARE_ZONE_STATUSES").Default("").String()
+	cfAccountName = kingpin.Flag("cloudflare-account-name", "Value of the account label on zone metrics.").
+			Envar("CLOUDFLARE_ACCOUNT_NAME").Default("default").String()
+	cfAccountsConfigFile = kingpin.Flag("cloudflare-accounts-config-file", "JSON file listing several accounts to scrape, each with its own credentials and zones. Replaces the single-account credential and zone flags.").
+				Envar("CLOUDFLARE_ACCOUNTS_CONFIG_FILE").Default("").String()
 	cfAPIBaseURL = kingpin.Flag("cloudflare-api-base-url", "Cloudflare regular (non-analytics) API base URL").
 			Envar("CLOUDFLARE_API_BASE_URL").Default("https://api.cloudflare.com/client/v4").String()
 	cfAnalyticsAPIBaseURL = kingpin.Flag("cloudflare-analytics-api-base-url", "Cloudflare analytics (graphql) API base URL").
 				Envar("CLOUDFLARE_ANALYTICS_API_BASE_URL").Default("https://api.cloudflare.com/client/v4/graphql").String()
+	cfZoneDiscoveryInterval = kingpin.Flag("cloudflare-zone-discovery-interval", "Interval at which to list each account's zones, picking up added and removed zones.").
+				Envar("CLOUDFLARE_ZONE_DISCOVERY_INTERVAL").Default("10m").Duration()
 	cfScrapeIntervalSeconds = kingpin.Flag("cloudflare-scrape-interval-seconds", "Interval at which to retrieve metrics from Cloudflare, separate from being scraped by prometheus").
 				Envar("CLOUDFLARE_SCRAPE_INTERVAL_SECONDS").Default("300").Int()
-	scrapeTimeoutSeconds = kingpin.Flag("scrape-timeout-seconds", "scrape timeout seconds").
+	apiMaxAttempts = kingpin.Flag("cloudflare-api-max-attempts", "Maximum number of attempts at each Cloudflare API request, retrying server and connection errors.").
+			Envar("CLOUDFLARE_API_MAX_ATTEMPTS").Default("3").Int()
+	apiRetryBaseDelay = kingpin.Flag("cloudflare-api-retry-base-delay", "Delay before the first retry of a Cloudflare API request, doubling with each further attempt.").
+				Envar("CLOUDFLARE_API_RETRY_BASE_DELAY").Default("1s").Duration()
+	apiRetryMaxDelay = kingpin.Flag("cloudflare-api-retry-max-delay", "Maximum delay between retries of a Cloudflare API request.").
+				Envar("CLOUDFLARE_API_RETRY_MAX_DELAY").Default("10s").Duration()
+	apiRetryJitter = kingpin.Flag("cloudflare-api-retry-jitter", "Fraction (0-1) by which retry delays are randomly shortened.").
+			Envar("CLOUDFLARE_API_RETRY_JITTER").Default("0.5").Float64()
+	httpProxyURL = kingpin.Flag("http-proxy-url", "Proxy for requests to the Cloudflare APIs. Defaults to the HTTPS_PROXY environment variable.").
+			Envar("CLOUDFLARE_EXPORTER_HTTP_PROXY_URL").Default("").String()
+	httpCAFile = kingpin.Flag("http-ca-file", "PEM file of CA certificates to trust for requests to the Cloudflare APIs, instead of the system roots.").
+			Envar("CLOUDFLARE_EXPORTER_HTTP_CA_FILE").Default("").String()
+	httpClientCertFile = kingpin.Flag("http-client-cert-file", "PEM client certificate to present on requests to the Cloudflare APIs. Requires --http-client-key-file.").
+				Envar("CLOUDFLARE_EXPORTER_HTTP_CLIENT_CERT_FILE").Default("").String()
+	httpClientKeyFile = kingpin.Flag("http-client-key-file", "PEM client key to present on requests to the Cloudflare APIs. Requires --http-client-cert-file.").
+				Envar("CLOUDFLARE_EXPORTER_HTTP_CLIENT_KEY_FILE").Default("").String()
+	httpConnectTimeout = kingpin.Flag("http-connect-timeout", "Timeout for establishing connections to the Cloudflare APIs.").
+				Envar("CLOUDFLARE_EXPORTER_HTTP_CONNECT_TIMEOUT").Default("10s").Duration()
+	httpTLSHandshakeTimeout = kingpin.Flag("http-tls-handshake-timeout", "Timeout for TLS handshakes with the Cloudflare APIs.").
+				Envar("CLOUDFLARE_EXPORTER_HTTP_TLS_HANDSHAKE_TIMEOUT").Default("10s").Duration()
+	httpResponseHeaderTimeout = kingpin.Flag("http-response-header-timeout", "Timeout waiting for response headers from the Cloudflare APIs.").
+					Envar("CLOUDFLARE_EXPORTER_HTTP_RESPONSE_HEADER_TIMEOUT").Default("20s").Duration()
+	httpIdleConnTimeout = kingpin.Flag("http-idle-conn-timeout", "How long idle connections to the Cloudflare APIs are kept open.").
+				Envar("CLOUDFLARE_EXPORTER_HTTP_IDLE_CONN_TIMEOUT").Default("90s").Duration()
+	httpMaxIdleConns = kingpin.Flag("http-max-idle-conns", "Maximum number of idle connections kept open to the Cloudflare APIs.").
+				Envar("CLOUDFLARE_EXPORTER_HTTP_MAX_IDLE_CONNS").Default("10").Int()
+	cfGraphqlQueryBudget = kingpin.Flag("cloudflare-graphql-query-budget", "Number of GraphQL queries each account may make per 5 minutes. Queries beyond this are deferred to later scrapes. 0 disables the budget.").
+				Envar("CLOUDFLARE_GRAPHQL_QUERY_BUDGET").Default("300").Int()
+	cfCombineZoneDatasets = kingpin.Flag("cloudflare-combine-zone-datasets", "Query all zone datasets in one GraphQL query, falling back to separate queries for datasets that fail or need paging.").
+				Envar("CLOUDFLARE_COMBINE_ZONE_DATASETS").Default("false").Bool()
+	cfEnableDatasets = kingpin.Flag("cloudflare-enable-datasets", fmt.Sprintf("Comma-separated list of optional datasets to scrape, of: %s.", strings.Join(optionalDatasets, ", "))).
+				Envar("CLOUDFLARE_ENABLE_DATASETS").Default("").String()
+	dnsQueryNames = kingpin.Flag("dns-query-names", "Comma-separated list of DNS query names to count separately in dnsAnalyticsAdaptiveGroups. Only these names are fetched, so queries of any other name are only counted in the totals.").
+			Envar("CLOUDFLARE_DNS_QUERY_NAMES").Default("").String()
+	httpCacheStatusByContentType = kingpin.Flag("http-cache-status-by-content-type", "Also count requests by cache status and content type in httpRequestsAdaptiveCacheStatus.").
+					Envar("CLOUDFLARE_HTTP_CACHE_STATUS_BY_CONTENT_TYPE").Default("false").Bool()
+	httpColos = kingpin.Flag("http-colos", "Comma-separated list of Cloudflare data centre (colo) codes to count separately in httpRequestsAdaptiveColos. Requests served by any other colo are counted as \"other\". Omit to count every colo separately.").
+			Envar("CLOUDFLARE_HTTP_COLOS").Default("").String()
+	httpColosByUpperTier = kingpin.Flag("http-colos-by-upper-tier", "Also count requests by colo and the upper tier colo that they were fetched through in httpRequestsAdaptiveColos.").
+				Envar("CLOUDFLARE_HTTP_COLOS_BY_UPPER_TIER").Default("false").Bool()
+	cfLBHealthInterval = kingpin.Flag("cloudflare-lb-health-interval", "Interval at which to refresh the health of load balancer pools and origins. 0 disables it.").
+				Envar("CLOUDFLARE_LB_HEALTH_INTERVAL").Default("0s").Duration()
+	cfZoneBatchSize = kingpin.Flag("cloudflare-zone-batch-size", "Number of zones to query together in each GraphQL query. 1 queries each zone separately.").
+			Envar("CLOUDFLARE_ZONE_BATCH_SIZE").Default("1").Int()
+	preflightChecks = kingpin.Flag("preflight", "Check credentials, zones and access to each dataset, with one of each account's zones, at startup, reporting problems in the log and the cloudflare_exporter_dataset_available metric.").
+			Envar("CLOUDFLARE_EXPORTER_PREFLIGHT").Default("true").Bool()
+	preflightStrict = kingpin.Flag("preflight-strict", "Exit if any preflight check or GraphQL schema validation fails, rather than start scraping.").
+			Envar("CLOUDFLARE_EXPORTER_PREFLIGHT_STRICT").Default("false").Bool()
+	validateGraphqlSchema = kingpin.Flag("validate-graphql-schema", "Introspect the Cloudflare GraphQL schema at startup and check every field the exporter queries against it, logging deprecated fields.").
+				Envar("CLOUDFLARE_EXPORTER_VALIDATE_GRAPHQL_SCHEMA").Default("false").Bool()
+	preflightTimeout = kingpin.Flag("preflight-timeout", "Time allowed for preflight checks. Checks not done by then are skipped.").
+				Envar("CLOUDFLARE_EXPORTER_PREFLIGHT_TIMEOUT").Default("1m").Duration()
+	scrapeTimeoutSeconds = kingpin.Flag("scrape-timeout-seconds", "Time allowed for listing zones and Cloudflare accounts, and for refreshing the load balancer health of each Cloudflare account and zone. Analytics queries are spread across the scrape interval instead.").
 				Envar("CLOUDFLARE_EXPORTER_SCRAPE_TIMEOUT_SECONDS").Default("30").Int()
 	logLevel                 = kingpin.Flag("log-level", "log level").Envar("CLOUDFLARE_EXPORTER_LOG_LEVEL").Default("info").String()
 	initialScrapeImmediately = kingpin.Flag("initial-scrape-immediately", "Scrape Cloudflare immediately at startup, or wait scrape-timeout-seconds. For development only.").
@@ -54,25 +147,90 @@ func main() {
 	kingpin.Version(version.Print("cloudflare_exporter"))
 	kingpin.Parse()
 
+	accounts, err := configuredAccounts()
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if *apiMaxAttempts < 1 {
+		kingpin.Fatalf("--cloudflare-api-max-attempts must be at least 1")
+	}
+	if *apiRetryJitter < 0 || *apiRetryJitter > 1 {
+		kingpin.Fatalf("--cloudflare-api-retry-jitter must be between 0 and 1")
+	}
+	if *cfZoneBatchSize < 1 {
+		kingpin.Fatalf("--cloudflare-zone-batch-size must be at least 1")
+	}
+	if *cfZoneDiscoveryInterval <= 0 {
+		kingpin.Fatalf("--cloudflare-zone-discovery-interval must be positive")
+	}
+	if *cfLBHealthInterval < 0 {
+		kingpin.Fatalf("--cloudflare-lb-health-interval must not be negative")
+	}
+	enabledDatasets := map[string]bool{}
+	for _, dataset := range splitList(*cfEnableDatasets) {
+		if !contains(optionalDatasets, dataset) {
+			kingpin.Fatalf("unknown dataset %q in --cloudflare-enable-datasets, expected one of %v", dataset, optionalDatasets)
+		}
+		enabledDatasets[dataset] = true
+	}
+	queryNames := map[string]bool{}
+	for _, queryName := range splitList(*dnsQueryNames) {
+		queryNames[strings.ToLower(strings.TrimSuffix(queryName, "."))] = true
+	}
+	colos := map[string]bool{}
+	for _, colo := range splitList(*httpColos) {
+		colos[strings.ToUpper(colo)] = true
+	}
+
+	httpClient, err := newHTTPClient(httpClientConfig{
+		proxyURL:              *httpProxyURL,
+		caFile:                *httpCAFile,
+		certFile:              *httpClientCertFile,
+		keyFile:               *httpClientKeyFile,
+		connectTimeout:        *httpConnectTimeout,
+		tlsHandshakeTimeout:   *httpTLSHandshakeTimeout,
+		responseHeaderTimeout: *httpResponseHeaderTimeout,
+		idleConnTimeout:       *httpIdleConnTimeout,
+		maxIdleConns:          *httpMaxIdleConns,
+	})
+	if err != nil {
+		kingpin.Fatalf("configuring HTTP client: %s", err)
+	}
+
 	logger := newPromLogger(*logLevel)
 	level.Info(logger).Log("msg", "starting cloudflare_exporter")
+	for _, account := range accounts {
+		level.Info(logger).Log("msg", "scraping account", "account", account.name)
+		if *cfGraphqlQueryBudget > 0 {
+			account.queryBudget = newQueryBudget(*cfGraphqlQueryBudget, time.Duration(*cfScrapeIntervalSeconds)*time.Second)
+		}
+	}
 
+	apiRetryPolicy := retryPolicy{
+		maxAttempts: *apiMaxAttempts, baseDelay: *apiRetryBaseDelay, maxDelay: *apiRetryMaxDelay, jitter: *apiRetryJitter,
+	}
 	cfExporter := &exporter{
-		email: *cfEmail, apiKey: *cfAPIKey, apiBaseURL: *cfAPIBaseURL,
-		graphqlClient:  graphql.NewClient(*cfAnalyticsAPIBaseURL),
-		scrapeTimeout:  time.Duration(*scrapeTimeoutSeconds) * time.Second,
-		scrapeInterval: time.Duration(*cfScrapeIntervalSeconds) * time.Second,
-		logger:         logger,
-		scrapeLock:     &sync.Mutex{},
-		lastSeenBucketTimes: &lastUpdatedTimes{
-			httpReqsByZone:          map[string]time.Time{},
-			firewallEventsByZone:    map[string]time.Time{},
-			healthCheckEventsByZone: map[string]time.Time{},
-		},
+		accounts:                 accounts,
+		restClient:               newRESTClient(*cfAPIBaseURL, httpClient, apiRetryPolicy, logger),
+		graphqlClient:            newGraphqlClient(*cfAnalyticsAPIBaseURL, httpClient),
+		retryPolicy:              apiRetryPolicy,
+		scrapeTimeout:            time.Duration(*scrapeTimeoutSeconds) * time.Second,
+		scrapeInterval:           time.Duration(*cfScrapeIntervalSeconds) * time.Second,
+		zoneBatchSize:            *cfZoneBatchSize,
+		combineZoneDatasets:      *cfCombineZoneDatasets,
+		enabledDatasets:          enabledDatasets,
+		dnsQueryNames:            queryNames,
+		cacheStatusByContentType: *httpCacheStatusByContentType,
+		colos:                    colos,
+		colosByUpperTier:         *httpColosByUpperTier,
+		lbHealthInterval:         *cfLBHealthInterval,
+		logger:                   logger,
+		scrapeLock:               &sync.Mutex{},
 	}
 
 	prometheus.MustRegister(version.NewCollector("cloudflare_exporter"))
 	registerMetrics(nil)
+	registerAccountMetrics(nil, accounts)
 
 	router := http.NewServeMux()
 	router.Handle("/metrics", promhttp.Handler())
@@ -92,8 +250,34 @@ func main() {
 		serverSocket.Close()
 	})
 
+	zoneDiscoveryCtx, cancelZoneDiscovery := context.WithCancel(context.Background())
+	runGroup.Add(func() error {
+		level.Info(logger).Log("msg", "starting zone discovery loop")
+		return cfExporter.discoverZones(zoneDiscoveryCtx, *cfZoneDiscoveryInterval)
+	}, func(error) {
+		level.Info(logger).Log("msg", "ending zone discovery loop")
+		cancelZoneDiscovery()
+	})
+
+	if cfExporter.lbHealthInterval > 0 {
+		lbHealthCtx, cancelLBHealth := context.WithCancel(context.Background())
+		runGroup.Add(func() error {
+			level.Info(logger).Log("msg", "starting load balancer health loop")
+			return cfExporter.refreshLoadBalancerHealth(lbHealthCtx, cfExporter.lbHealthInterval)
+		}, func(error) {
+			level.Info(logger).Log("msg", "ending load balancer health loop")
+			cancelLBHealth()
+		})
+	}
+
 	cfScrapeCtx, cancelCfScrape := context.WithCancel(context.Background())
 	runGroup.Add(func() error {
+		err := cfExporter.startupChecks(
+			cfScrapeCtx, *preflightChecks, *validateGraphqlSchema, *preflightStrict, *preflightTimeout,
+		)
+		if err != nil {
+			return err
+		}
 		level.Info(logger).Log("msg", "starting Cloudflare scrape loop")
 		return cfExporter.scrapeCloudflare(cfScrapeCtx)
 	}, func(error) {
@@ -107,29 +291,86 @@ func main() {
 	}
 }
 
+func configuredAccounts() ([]*account, error) {
+	zoneFilterFlags := zoneFilterConfig{
+		Names:        splitList(*cfZones),
+		IDs:          splitList(*zoneIDFilter),
+		IncludeRegex: *zoneIncludeRegex,
+		ExcludeRegex: *zoneExcludeRegex,
+		Plans:        splitList(*zonePlanFilter),
+		Statuses:     splitList(*zoneStatusFilter),
+	}
+	if *cfAccountsConfigFile == "" {
+		creds := credentials{
+			email:        *cfEmail,
+			apiKey:       *cfAPIKey,
+			apiKeyFile:   newCredentialFile(*cfAPIKeyFile),
+			apiToken:     *cfAPIToken,
+			apiTokenFile: newCredentialFile(*cfAPITokenFile),
+		}
+		if err := creds.validate(); err != nil {
+			return nil, err
+		}
+		zoneFilter, err := newZoneFilter(zoneFilterFlags)
+		if err != nil {
+			return nil, err
+		}
+		return []*account{{name: *cfAccountName, credentials: creds, zoneFilter: zoneFilter}}, nil
+	}
+
+	if *cfEmail != "" || *cfAPIKey != "" || *cfAPIKeyFile != "" || *cfAPIToken != "" || *cfAPITokenFile != "" || zoneFilterFlags.isSet() {
+		return nil, fmt.Errorf("--cloudflare-accounts-config-file cannot be combined with single-account credential or zone flags")
+	}
+	accounts, err := loadAccountsFile(*cfAccountsConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", *cfAccountsConfigFile, err)
+	}
+	return accounts, nil
+}
+
 type exporter struct {
-	email          string
-	apiKey         string
-	apiBaseURL     string
+	accounts       []*account
+	restClient     *restClient
 	graphqlClient  graphqlClient
+	retryPolicy    retryPolicy
 	scrapeInterval time.Duration
 	scrapeTimeout  time.Duration
 	logger         log.Logger
 
+	zoneBatchSize       int
+	combineZoneDatasets bool
+	// enabledDatasets are the optional datasets to scrape.
+	enabledDatasets map[string]bool
+	// dnsQueryNames are the DNS query names counted separately, in lower case.
+	dnsQueryNames map[string]bool
+	// cacheStatusByContentType is whether requests are also counted by cache
+	// status and content type.
+	cacheStatusByContentType bool
+	// colos are the colo codes counted separately, in upper case, or empty if
+	// every colo is.
+	colos map[string]bool
+	// colosByUpperTier is whether requests are also counted by colo and upper
+	// tier colo.
+	colosByUpperTier bool
+	// lbHealthInterval is the interval at which load balancer health is
+	// refreshed, or 0 if it is not.
+	lbHealthInterval time.Duration
+
 	scrapeLock               *sync.Mutex
-	lastSeenBucketTimes      *lastUpdatedTimes
 	consecutiveRateLimitErrs int
 	skipNextScrapes          int
 }
 
-type lastUpdatedTimes struct {
-	httpReqsByZone          map[string]time.Time
-	firewallEventsByZone    map[string]time.Time
-	healthCheckEventsByZone map[string]time.Time
-}
+// lastUpdatedTimes holds the time of the latest bucket that an account has
+// counted from each dataset, by dataset name, then by the tag of the zone or
+// Cloudflare account it was counted for.
+type lastUpdatedTimes map[string]map[string]time.Time
 
-type graphqlClient interface {
-	Run(context.Context, *graphql.Request, interface{}) error
+func (t lastUpdatedTimes) dataset(name string) map[string]time.Time {
+	if t[name] == nil {
+		t[name] = map[string]time.Time{}
+	}
+	return t[name]
 }
 
 func (e *exporter) scrapeCloudflare(ctx context.Context) error {
@@ -138,7 +379,7 @@ func (e *exporter) scrapeCloudflare(ctx context.Context) error {
 		// Risks double counting on restart. Only useful for development.
 		if err := e.scrapeCloudflareOnce(ctx); err != nil {
 			level.Error(e.logger).Log("error", err)
-			cfScrapeErrs.Inc()
+			cfScrapeErrs.WithLabelValues(string(errorKindOf(err))).Inc()
 		}
 	}
 	ticker := time.Tick(e.scrapeInterval)
@@ -157,15 +398,11 @@ func (e *exporter) scrapeCloudflare(ctx context.Context) error {
 				// might never notice that we are not updating our cached metrics.
 				// Instead, we should alert on the exporter_cloudflare_scrape_errors
 				// metric.
-				level.Error(e.logger).Log("error", err)
-				cfScrapeErrs.Inc()
-
-				// We've observed 2 error messages relating to rate limits in the wild:
-				//   - "rate limiter budget depleted, please try again later"
-				//   - "graphql: limit reached, please try again later"
-				// We crudely check for the substring "limit", and err on the side of
-				// applying backoff on errors containing it.
-				if strings.Contains(err.Error(), "limit") {
+				kind := errorKindOf(err)
+				level.Error(e.logger).Log("error", err, "kind", kind)
+				cfScrapeErrs.WithLabelValues(string(kind)).Inc()
+
+				if kind.backOff() {
 					// Keep track of consecutive rate limit errors seen, and back off one
 					// extra scrape per consecutive error.
 					e.consecutiveRateLimitErrs++
@@ -182,26 +419,36 @@ func (e *exporter) scrapeCloudflare(ctx context.Context) error {
 	}
 }
 
+// scrapeCloudflareOnce scrapes every account. The scrape lock is only held
+// while reading and updating each account's state, not across queries, which
+// are paced over the whole scrape interval, so that zone discovery and load
+// balancer health refreshes are not held up for as long.
 func (e *exporter) scrapeCloudflareOnce(ctx context.Context) error {
-	e.scrapeLock.Lock()
-	defer e.scrapeLock.Unlock()
-
 	logger := level.Info(log.With(e.logger, "event", "scraping cloudflare"))
 	logger.Log("msg", "starting")
 	cfScrapes.Inc()
 
-	ctx, cancel := context.WithTimeout(ctx, e.scrapeTimeout)
+	// Queries are spread across the scrape interval, to keep within each
+	// account's query budget, and must be done by the next scrape.
+	ctx, cancel := context.WithTimeout(ctx, e.scrapeInterval)
 	defer cancel()
+	deadline, _ := ctx.Deadline()
 
 	duration, err := timeOperation(func() error {
-		var zones map[string]string
-		zones, err := e.getZones(ctx)
-		if err != nil {
-			return err
+		// Scrape every account even if an earlier one fails, so that one broken
+		// account does not stall metrics for the others.
+		var errs scrapeErrors
+		for i, account := range e.accounts {
+			// Each account has an equal share of the time left, so that pacing
+			// one account's queries does not leave none for the others.
+			accountCtx, cancelAccount := context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(e.accounts)-i))
+			err := e.scrapeAccount(accountCtx, account)
+			cancelAccount()
+			if err != nil {
+				errs.add(fmt.Errorf("account %s: %w", account.name, err))
+			}
 		}
-		zonesActive.Set(float64(len(zones)))
-
-		return e.getZoneAnalytics(ctx, zones)
+		return errs.err()
 	})
 	if err != nil {
 		return err
@@ -213,127 +460,626 @@ func (e *exporter) scrapeCloudflareOnce(ctx context.Context) error {
 	return nil
 }
 
-func (e *exporter) getZoneAnalytics(ctx context.Context, zones map[string]string) error {
-	if err := e.getZoneAnalyticsKind(
-		ctx, zones, e.lastSeenBucketTimes.httpReqsByZone, httpReqsGqlReq,
-		extractZoneHTTPRequests, "graphql:zones:httpRequests1mGroups",
-	); err != nil {
-		return err
+func (e *exporter) scrapeAccount(ctx context.Context, account *account) error {
+	e.scrapeLock.Lock()
+	zones := account.zones
+	e.scrapeLock.Unlock()
+	if zones == nil {
+		// Zone discovery has not yet succeeded for this account.
+		var err error
+		if zones, err = e.getZones(ctx, account); err != nil {
+			return err
+		}
+		e.scrapeLock.Lock()
+		e.updateZones(account, zones)
+		e.scrapeLock.Unlock()
 	}
-	if err := e.getZoneAnalyticsKind(
-		ctx, zones, e.lastSeenBucketTimes.firewallEventsByZone, firewallEventsGqlReq,
-		extractZoneFirewallEvents, "graphql:zones:firewallEventsAdaptiveGroups",
-	); err != nil {
-		return err
+	e.scrapeLock.Lock()
+	plannedQueries := e.plannedQueries(account)
+	scrapesAccountDatasets := len(e.accountDatasets(account)) > 0
+	cloudflareAccounts := account.cloudflareAccounts
+	e.scrapeLock.Unlock()
+
+	account.queryBudget.pace(ctx, plannedQueries)
+	zoneErr := e.getZoneAnalytics(ctx, account, zones)
+	if !scrapesAccountDatasets || endsAnalytics(ctx, zoneErr) {
+		return zoneErr
 	}
-	if err := e.getZoneAnalyticsKind(
-		ctx, zones, e.lastSeenBucketTimes.healthCheckEventsByZone, healthCheckEventsGqlReq,
-		extractZoneHealthCheckEvents, "graphql:zones:healthCheckEventsGroups",
-	); err != nil {
-		return err
+
+	var errs scrapeErrors
+	if zoneErr != nil {
+		errs.add(zoneErr)
 	}
-	return nil
+	if cloudflareAccounts == nil {
+		// Cloudflare account discovery has not yet succeeded either.
+		var err error
+		if cloudflareAccounts, err = e.getCloudflareAccounts(ctx, account); err != nil {
+			errs.add(err)
+			return errs.err()
+		}
+		e.scrapeLock.Lock()
+		e.updateCloudflareAccounts(account, cloudflareAccounts)
+		e.scrapeLock.Unlock()
+	}
+	if err := e.getAccountAnalytics(ctx, account); err != nil {
+		errs.add(err)
+	}
+	return errs.err()
+}
+
+// plannedQueries returns how many queries an account's scrape will make, not
+// counting further pages of results. The caller must hold the scrape lock.
+func (e *exporter) plannedQueries(account *account) int {
+	batches := batchZoneQueries(datasetQueries(e.zoneDatasets(account), account.zones), e.zoneBatchSize, e.combineZoneDatasets)
+	return len(batches) + len(datasetQueries(e.accountDatasets(account), account.cloudflareAccounts))
 }
 
+// zoneDatasets lists the zone-scoped datasets to scrape, each queried
+// separately for each zone or batch of zones, or combined with the others. The
+// caller must hold the scrape lock, as they share the account's times counted
+// up to.
+func (e *exporter) zoneDatasets(account *account) []analyticsDataset {
+	var datasets []analyticsDataset
+	for _, dataset := range []analyticsDataset{
+		{
+			"httpRequests1mGroups", "graphql:zones:httpRequests1mGroups", httpReqsGqlReq, httpReqsGqlSelection,
+			extractZoneHTTPRequests, account.lastSeen("httpRequests1mGroups"),
+		},
+		{
+			"firewallEventsAdaptiveGroups", "graphql:zones:firewallEventsAdaptiveGroups", firewallEventsGqlReq,
+			firewallEventsGqlSelection, extractZoneFirewallEvents, account.lastSeen("firewallEventsAdaptiveGroups"),
+		},
+		{
+			"healthCheckEventsGroups", "graphql:zones:healthCheckEventsGroups", healthCheckEventsGqlReq,
+			healthCheckEventsGqlSelection, extractZoneHealthCheckEvents, account.lastSeen("healthCheckEventsGroups"),
+		},
+		{
+			"loadBalancingRequestsAdaptiveGroups", "graphql:zones:loadBalancingRequestsAdaptiveGroups",
+			loadBalancingRequestsGqlReq, loadBalancingRequestsGqlSelection, extractZoneLoadBalancingRequests,
+			account.lastSeen("loadBalancingRequestsAdaptiveGroups"),
+		},
+		{
+			"dnsAnalyticsAdaptiveGroups", "graphql:zones:dnsAnalyticsAdaptiveGroups", dnsAnalyticsGqlReq,
+			dnsAnalyticsGqlSelection, extractZoneDNSQueries, account.lastSeen("dnsAnalyticsAdaptiveGroups"),
+		},
+		e.httpCacheStatusDataset(account),
+		{
+			"httpRequestsAdaptiveLatency", "graphql:zones:httpRequestsAdaptiveLatency", httpLatencyGqlReq,
+			httpLatencyGqlSelection, extractZoneHTTPLatency, account.lastSeen("httpRequestsAdaptiveLatency"),
+		},
+		e.httpColosDataset(account),
+		{
+			"httpRequestsAdaptiveColo5xx", "graphql:zones:httpRequestsAdaptiveColo5xx", httpColo5xxGqlReq,
+			httpColo5xxGqlSelection, e.extractZoneHTTPColo5xx, account.lastSeen("httpRequestsAdaptiveColo5xx"),
+		},
+	} {
+		if e.datasetEnabled(dataset.name) {
+			datasets = append(datasets, dataset)
+		}
+	}
+	if len(e.dnsQueryNames) > 0 && e.datasetEnabled("dnsAnalyticsAdaptiveQueryNames") {
+		datasets = append(datasets, e.dnsQueryNamesDataset(account))
+	}
+	return datasets
+}
+
+// dnsQueryNamesDataset queries the DNS query names to be counted separately.
+// The selection is built from the names, so the request is too.
+func (e *exporter) dnsQueryNamesDataset(account *account) analyticsDataset {
+	var queryNames []string
+	for queryName := range e.dnsQueryNames {
+		queryNames = append(queryNames, queryName)
+	}
+	sort.Strings(queryNames)
+	selection := dnsQueryNamesGqlSelection(queryNames)
+	return analyticsDataset{
+		"dnsAnalyticsAdaptiveQueryNames", "graphql:zones:dnsAnalyticsAdaptiveQueryNames",
+		newGraphqlRequest(zoneGqlQuery(selection)), selection, extractZoneDNSQueryNames,
+		account.lastSeen("dnsAnalyticsAdaptiveQueryNames"),
+	}
+}
+
+// httpCacheStatusDataset groups requests by content type only if they are to
+// be counted by it.
+func (e *exporter) httpCacheStatusDataset(account *account) analyticsDataset {
+	dataset := analyticsDataset{
+		"httpRequestsAdaptiveCacheStatus", "graphql:zones:httpRequestsAdaptiveCacheStatus", httpCacheStatusGqlReq,
+		httpCacheStatusGqlSelection, e.extractZoneHTTPCacheStatus,
+		account.lastSeen("httpRequestsAdaptiveCacheStatus"),
+	}
+	if e.cacheStatusByContentType {
+		dataset.req, dataset.selection = httpCacheStatusContentTypeGqlReq, httpCacheStatusContentTypeGqlSelection
+	}
+	return dataset
+}
+
+// httpColosDataset groups requests by upper tier colo only if they are to be
+// counted by it.
+func (e *exporter) httpColosDataset(account *account) analyticsDataset {
+	dataset := analyticsDataset{
+		"httpRequestsAdaptiveColos", "graphql:zones:httpRequestsAdaptiveColos", httpColosGqlReq,
+		httpColosGqlSelection, e.extractZoneHTTPColos, account.lastSeen("httpRequestsAdaptiveColos"),
+	}
+	if e.colosByUpperTier {
+		dataset.req, dataset.selection = httpColosUpperTierGqlReq, httpColosUpperTierGqlSelection
+	}
+	return dataset
+}
+
+// datasetEnabled reports whether a dataset is to be scraped: every dataset
+// is, unless it is optional and not enabled.
+func (e *exporter) datasetEnabled(name string) bool {
+	if enabledWith, ok := companionDatasets[name]; ok {
+		name = enabledWith
+	}
+	return !contains(optionalDatasets, name) || e.enabledDatasets[name]
+}
+
+// zoneQueryBatch is a set of zones whose data from one or more datasets is
+// requested in a single query.
+type zoneQueryBatch struct {
+	datasets []analyticsDataset
+	zoneIDs  []string
+}
+
+// batchZoneQueries groups queries into batches of at most batchSize zones.
+// Each batch queries a single dataset or, if combined, every dataset. Batches
+// are ordered by their stalest query, so that the priority given by
+// datasetQueries is kept.
+func batchZoneQueries(queries []datasetQuery, batchSize int, combined bool) []*zoneQueryBatch {
+	var combinedDatasets []analyticsDataset
+	seenDatasets := map[string]bool{}
+	for _, query := range queries {
+		if !seenDatasets[query.dataset.requestKind] {
+			seenDatasets[query.dataset.requestKind] = true
+			combinedDatasets = append(combinedDatasets, query.dataset)
+		}
+	}
+
+	var batches []*zoneQueryBatch
+	openBatches := map[string]*zoneQueryBatch{}
+	batchedZones := map[string]bool{}
+	for _, query := range queries {
+		key, datasets := query.dataset.requestKind, []analyticsDataset{query.dataset}
+		if combined {
+			if batchedZones[query.tag] {
+				continue
+			}
+			batchedZones[query.tag] = true
+			key, datasets = "", combinedDatasets
+		}
+		batch := openBatches[key]
+		if batch == nil || len(batch.zoneIDs) >= batchSize {
+			batch = &zoneQueryBatch{datasets: datasets}
+			openBatches[key] = batch
+			batches = append(batches, batch)
+		}
+		batch.zoneIDs = append(batch.zoneIDs, query.tag)
+	}
+	return batches
+}
+
+// getZoneAnalytics queries every dataset for every zone. Failures are
+// recorded per zone and dataset, and the remaining queries still made, with
+// all failures returned together. Only rate limiting, the query budget running
+// out, or the scrape timing out end the queries early.
+func (e *exporter) getZoneAnalytics(ctx context.Context, account *account, zones map[string]string) error {
+	e.scrapeLock.Lock()
+	batches := batchZoneQueries(datasetQueries(e.zoneDatasets(account), zones), e.zoneBatchSize, e.combineZoneDatasets)
+	e.scrapeLock.Unlock()
+	var errs scrapeErrors
+	for i, batch := range batches {
+		var err error
+		if len(batch.datasets) == 1 {
+			err = e.getZoneAnalyticsKind(ctx, account, zones, batch.datasets[0], batch.zoneIDs, true, &errs)
+		} else {
+			err = e.getZoneAnalyticsCombined(ctx, account, zones, batch, &errs)
+		}
+		if errors.Is(err, errQueryBudgetExhausted) {
+			// Not an error: these queries will be first in line next time.
+			level.Info(e.logger).Log(
+				"msg", "GraphQL query budget exhausted, deferring queries to the next scrape",
+				"account", account.name, "deferred", len(batches)-i,
+			)
+			graphqlQueriesDeferred.WithLabelValues(account.name).Add(float64(len(batches) - i))
+			break
+		}
+		if err != nil {
+			errs.add(err)
+			break
+		}
+	}
+	return errs.err()
+}
+
+// endsAnalytics reports whether an error should stop all further analytics
+// queries in this scrape.
+func endsAnalytics(ctx context.Context, err error) bool {
+	return errors.Is(err, errQueryBudgetExhausted) || errorKindOf(err) == errorKindRateLimited || ctx.Err() != nil
+}
+
+// getZoneAnalyticsKind queries a dataset for a batch of zones, paging through
+// the zones whose results reach the API limit until all are up to date. The
+// first query overlaps the previous scrape's if overlap is set, as it is unless
+// continuing from an earlier page. The outcome for each zone is recorded, and
+// failures added to errs. Only errors that should end the scrape's zone
+// analytics queries are returned.
 func (e *exporter) getZoneAnalyticsKind(
-	ctx context.Context, zones map[string]string, lastSeenBucketTimes map[string]time.Time,
-	req *graphql.Request, extract extractFunc, requestKind string,
+	ctx context.Context, account *account, zones map[string]string, dataset analyticsDataset, zoneIDs []string,
+	overlap bool, errs *scrapeErrors,
 ) error {
-	for zoneID, zoneName := range zones {
-		logger := level.Debug(log.With(e.logger, "event", "get zone analytics", "zone", zoneName, "request", requestKind))
-		for {
-			lastDateTimeCounted := lastSeenBucketTimes[zoneID]
-			if lastDateTimeCounted == (time.Time{}) {
-				lastDateTimeCounted = time.Now().UTC().Add(-e.scrapeInterval)
+	for ; len(zoneIDs) > 0; overlap = false {
+		if err := account.queryBudget.take(ctx); err != nil {
+			return err
+		}
+		e.scrapeLock.Lock()
+		lastDateTimesCounted, startTime := e.queryStartTime(dataset.lastSeenBucketTimes, zoneIDs, overlap)
+		e.scrapeLock.Unlock()
+		req, zoneName := e.zoneAnalyticsRequest([]analyticsDataset{dataset}, zones, zoneIDs, []time.Time{startTime})
+		level.Debug(e.logger).Log(
+			"event", "get zone analytics", "account", account.name, "zone", zoneName, "request", dataset.requestKind,
+			"msg", "starting", "start_time", startTime.String(),
+		)
+		var gqlResp cloudflareResp
+		if err := e.makeGraphqlRequest(ctx, log.With(e.logger), dataset.requestKind, account, zoneName, req, &gqlResp); err != nil {
+			for _, zoneID := range zoneIDs {
+				recordScrape(account, e.zoneScope(), zones[zoneID], dataset, err)
 			}
-			logger.Log("msg", "starting", "last_datetime_bucket", lastDateTimeCounted.String())
-			req.Var("zone", zoneID)
-			// Add some grace time so that adjacent polling loops overlap in query
-			// range, to avoid missing metrics. When we come to extract the zone data,
-			// we exclude time buckets that occur before the lastDateTimeCounted,
-			// avoiding double counting.
-			req.Var("start_time", lastDateTimeCounted.Add(-5*time.Minute))
-			var gqlResp cloudflareResp
-			if err := e.makeGraphqlRequest(ctx, log.With(e.logger), req, &gqlResp); err != nil {
+			if endsAnalytics(ctx, err) {
 				return err
 			}
+			errs.add(fmt.Errorf("zone(s) %s: %s: %w", describeZones(zones, zoneIDs), dataset.name, err))
+			return nil
+		}
 
-			if len(gqlResp.Viewer.Zones) != 1 {
-				// The response length should only be zero if the zone has disappeared
-				// since querying for them in this polling loop, and should never be >=2.
-				return fmt.Errorf("expected 1 zone (%s), got %d", zoneName, len(gqlResp.Viewer.Zones))
+		respZones := e.respZones(account, zones, zoneIDs, []analyticsDataset{dataset}, gqlResp, errs)
+		var incomplete []string
+		for _, zoneID := range zoneIDs {
+			zone, ok := respZones[zoneID]
+			if !ok {
+				continue
 			}
-			zone := gqlResp.Viewer.Zones[0]
-			results, lastDateTimeCounted, err := extract(zone, zones, lastDateTimeCounted)
+			more, err := e.extractZoneDataset(account, dataset, zone, zones, lastDateTimesCounted[zoneID], overlap)
 			if err != nil {
-				return err
+				recordScrape(account, e.zoneScope(), zones[zoneID], dataset, err)
+				errs.add(fmt.Errorf("zone %s: %s: %w", zones[zoneID], dataset.name, err))
+				continue
 			}
-			lastSeenBucketTimes[zone.ZoneTag] = lastDateTimeCounted
-			if time.Since(lastDateTimeCounted) > maxTimeWindow {
-				// For very quiet data sets, in which either no new data points are
-				// returned, or due to intentionally overlapping query windows, the
-				// latest seen timestamp for a data set remains the same across many
-				// successive queries, it's possible that the query window would grow to
-				// exceed the API maximum for this data set. Cap the window to prevent
-				// this.
-				lastSeenBucketTimes[zone.ZoneTag] = time.Now().UTC().Add(maxTimeWindow * -1)
+			if more {
+				incomplete = append(incomplete, zoneID)
+				continue
 			}
-			logger.Log("msg", "finished", "last_datetime_bucket", lastSeenBucketTimes[zone.ZoneTag].String(), "results", results)
+			recordScrape(account, e.zoneScope(), zones[zoneID], dataset, nil)
+		}
+		zoneIDs = incomplete
+	}
+	return nil
+}
 
-			if results < apiMaxLimit {
-				break
+// getZoneAnalyticsCombined queries every dataset in a batch at once. Datasets
+// whose results need paging, or all of them if the combined query fails, are
+// then queried separately. Outcomes are recorded as by getZoneAnalyticsKind.
+func (e *exporter) getZoneAnalyticsCombined(
+	ctx context.Context, account *account, zones map[string]string, batch *zoneQueryBatch, errs *scrapeErrors,
+) error {
+	if err := account.queryBudget.take(ctx); err != nil {
+		return err
+	}
+	lastDateTimesCounted := map[string]map[string]time.Time{}
+	var startTimes []time.Time
+	e.scrapeLock.Lock()
+	for _, dataset := range batch.datasets {
+		datasetLastDateTimesCounted, startTime := e.queryStartTime(dataset.lastSeenBucketTimes, batch.zoneIDs, true)
+		lastDateTimesCounted[dataset.requestKind] = datasetLastDateTimesCounted
+		startTimes = append(startTimes, startTime)
+	}
+	e.scrapeLock.Unlock()
+	req, zoneName := e.zoneAnalyticsRequest(batch.datasets, zones, batch.zoneIDs, startTimes)
+	level.Debug(e.logger).Log(
+		"event", "get zone analytics", "account", account.name, "zone", zoneName, "request", combinedRequestKind,
+		"msg", "starting",
+	)
+	var gqlResp cloudflareResp
+	if err := e.makeGraphqlRequest(ctx, log.With(e.logger), combinedRequestKind, account, zoneName, req, &gqlResp); err != nil {
+		if endsAnalytics(ctx, err) {
+			return err
+		}
+		// A dataset that is unavailable to some zones fails the whole query.
+		// Querying separately isolates it, and reports its own error.
+		level.Warn(e.logger).Log(
+			"msg", "combined zone analytics query failed, falling back to separate queries",
+			"account", account.name, "zone", zoneName, "error", err,
+		)
+		for _, dataset := range batch.datasets {
+			if err := e.getZoneAnalyticsKind(ctx, account, zones, dataset, batch.zoneIDs, true, errs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	respZones := e.respZones(account, zones, batch.zoneIDs, batch.datasets, gqlResp, errs)
+	for _, dataset := range batch.datasets {
+		var incomplete []string
+		for _, zoneID := range batch.zoneIDs {
+			zone, ok := respZones[zoneID]
+			if !ok {
+				continue
+			}
+			more, err := e.extractZoneDataset(
+				account, dataset, zone, zones, lastDateTimesCounted[dataset.requestKind][zoneID], true,
+			)
+			if err != nil {
+				recordScrape(account, e.zoneScope(), zones[zoneID], dataset, err)
+				errs.add(fmt.Errorf("zone %s: %s: %w", zones[zoneID], dataset.name, err))
+				continue
+			}
+			if more {
+				incomplete = append(incomplete, zoneID)
+				continue
+			}
+			recordScrape(account, e.zoneScope(), zones[zoneID], dataset, nil)
+		}
+		if len(incomplete) > 0 {
+			if err := e.getZoneAnalyticsKind(ctx, account, zones, dataset, incomplete, false, errs); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-func (e *exporter) makeGraphqlRequest(ctx context.Context, logger log.Logger, req *graphql.Request, resp interface{}) error {
-	req.Header.Set("X-AUTH-EMAIL", e.email)
-	req.Header.Set("X-AUTH-KEY", e.apiKey)
-	req.Var("limit", apiMaxLimit)
-	duration, err := timeOperation(func() error {
-		return e.graphqlClient.Run(ctx, req, &resp)
-	})
-	level.Debug(logger).Log("duration", duration.Seconds(), "msg", "finished request")
-	return err
+// respZones returns the zones in a response by ID, recording a failure for
+// each queried zone that is missing from it.
+func (e *exporter) respZones(
+	account *account, zones map[string]string, zoneIDs []string, datasets []analyticsDataset, gqlResp cloudflareResp,
+	errs *scrapeErrors,
+) map[string]scopeResp {
+	respZones := map[string]scopeResp{}
+	for _, zone := range gqlResp.Viewer.Zones {
+		respZones[zone.ZoneTag] = zone
+	}
+	for _, zoneID := range zoneIDs {
+		if _, ok := respZones[zoneID]; ok {
+			continue
+		}
+		// A zone should only be missing if it has disappeared since it was
+		// last listed.
+		err := fmt.Errorf("zone %s missing from response", zones[zoneID])
+		for _, dataset := range datasets {
+			recordScrape(account, e.zoneScope(), zones[zoneID], dataset, err)
+		}
+		errs.add(err)
+	}
+	return respZones
 }
 
-func (e *exporter) getZones(ctx context.Context) (map[string]string, error) {
-	// TODO handle >50 zones (the API maximum per page) by requesting successive
-	// pages. For now, we don't anticipate having >50 zones any time soon.
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.apiBaseURL+"/zones?per_page=50", nil)
-	if err != nil {
-		return nil, err
+func describeZones(zones map[string]string, zoneIDs []string) string {
+	var zoneNames []string
+	for _, zoneID := range zoneIDs {
+		zoneNames = append(zoneNames, zones[zoneID])
 	}
-	req.Header.Set("X-AUTH-EMAIL", e.email)
-	req.Header.Set("X-AUTH-KEY", e.apiKey)
+	return strings.Join(zoneNames, ", ")
+}
 
-	var zones map[string]string
-	duration, err := timeOperation(func() error {
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return err
+// queryStartTime returns the time up to which each zone or Cloudflare account,
+// by tag, has been counted for a dataset, and the time from which to query them
+// all. Only the first query of a scrape overlaps the previous one: further
+// pages of results carry on from the time counted up to. The caller must hold
+// the scrape lock.
+func (e *exporter) queryStartTime(
+	lastSeenBucketTimes map[string]time.Time, tags []string, overlap bool,
+) (map[string]time.Time, time.Time) {
+	// Each zone in a batch may have been counted up to a different time. Query
+	// from the earliest of them: extracting the zone data excludes time buckets
+	// already counted for each zone.
+	lastDateTimesCounted := map[string]time.Time{}
+	var earliestDateTimeCounted time.Time
+	for _, tag := range tags {
+		lastDateTimeCounted := lastSeenBucketTimes[tag]
+		if lastDateTimeCounted == (time.Time{}) {
+			lastDateTimeCounted = time.Now().UTC().Add(-e.scrapeInterval)
 		}
-		if resp.StatusCode != http.StatusOK {
-			err = fmt.Errorf("expected status 200, got %d", resp.StatusCode)
-			return err
+		lastDateTimesCounted[tag] = lastDateTimeCounted
+		if earliestDateTimeCounted.IsZero() || lastDateTimeCounted.Before(earliestDateTimeCounted) {
+			earliestDateTimeCounted = lastDateTimeCounted
 		}
+	}
+	// Add some grace time so that adjacent polling loops overlap in query
+	// range, to avoid missing metrics. When we come to extract the zone data,
+	// we exclude time buckets that occur before the lastDateTimeCounted,
+	// avoiding double counting.
+	if !overlap {
+		return lastDateTimesCounted, earliestDateTimeCounted
+	}
+	return lastDateTimesCounted, earliestDateTimeCounted.Add(-5 * time.Minute)
+}
+
+// extractZoneDataset records a zone's results from a dataset, returning
+// whether there are more to page through, as by nextPage. Paging that stalls
+// is resumed as by resumePaging, given whether the query overlapped the
+// previous scrape's.
+func (e *exporter) extractZoneDataset(
+	account *account, dataset analyticsDataset, zone scopeResp, zones map[string]string, lastDateTimeCounted time.Time,
+	overlap bool,
+) (bool, error) {
+	e.scrapeLock.Lock()
+	results, latestDateTimeCounted, err := dataset.extract(account.name, zone, zones[zone.ZoneTag], lastDateTimeCounted)
+	if err == nil {
+		latestDateTimeCounted = updateLastSeen(dataset.lastSeenBucketTimes, zone.ZoneTag, latestDateTimeCounted)
+	}
+	e.scrapeLock.Unlock()
+	if err != nil {
+		return false, err
+	}
+	level.Debug(e.logger).Log(
+		"event", "get zone analytics", "account", account.name, "zone", zones[zone.ZoneTag],
+		"request", dataset.requestKind, "msg", "finished",
+		"last_datetime_bucket", latestDateTimeCounted.String(), "results", results,
+	)
+	more, err := nextPage(results, lastDateTimeCounted, latestDateTimeCounted)
+	if errors.Is(err, errPagingStalled) {
+		e.resumePaging(account, e.zoneScope(), dataset, zone.ZoneTag, zones[zone.ZoneTag], lastDateTimeCounted, overlap)
+		return true, nil
+	}
+	return more, err
+}
+
+var errPagingStalled = errors.New("a full page of results counted no newer time buckets")
+
+// nextPage reports whether a zone or Cloudflare account has more results to
+// page through, given how many the last page had and the times counted up to
+// before and after it. The next query after a full page that counted no newer
+// bucket would return the same page again, so it returns errPagingStalled for
+// the caller to resumePaging instead.
+func nextPage(results int, lastDateTimeCounted, latestDateTimeCounted time.Time) (bool, error) {
+	if results < apiMaxLimit {
+		return false, nil
+	}
+	if !latestDateTimeCounted.After(lastDateTimeCounted) {
+		return false, errPagingStalled
+	}
+	return true, nil
+}
+
+// resumePaging lets paging carry on after a full page that counted no newer
+// bucket. If the query overlapped the previous scrape's, the page may only
+// have held buckets counted already, and the next query is made without the
+// overlap. Otherwise, a single minute bucket has more groups than fit in a
+// page, and can never be paged through. Rather than stall, the time counted up
+// to is moved on a minute at a time, to the last second of each, until the
+// busy minute has been skipped: a query from the last second of a minute
+// leaves out nearly all of it.
+func (e *exporter) resumePaging(
+	account *account, s scope, dataset analyticsDataset, tag, name string, lastDateTimeCounted time.Time, overlap bool,
+) {
+	if overlap {
+		return
+	}
+	level.Warn(e.logger).Log(
+		"msg", "skipping a minute with more results than fit in one response", "account", account.name,
+		s.label, name, "dataset", dataset.name, "last_datetime_bucket", lastDateTimeCounted.String(),
+	)
+	e.scrapeLock.Lock()
+	minute := lastDateTimeCounted.Add(time.Second).Truncate(time.Minute)
+	dataset.lastSeenBucketTimes[tag] = minute.Add(time.Minute - time.Second)
+	e.scrapeLock.Unlock()
+}
+
+// updateLastSeen records the latest bucket counted from a dataset for a zone or
+// Cloudflare account, returning the time recorded. The caller must hold the
+// scrape lock.
+func updateLastSeen(lastSeenBucketTimes map[string]time.Time, tag string, lastDateTimeCounted time.Time) time.Time {
+	if time.Since(lastDateTimeCounted) > maxTimeWindow {
+		// For very quiet data sets, in which either no new data points are
+		// returned, or due to intentionally overlapping query windows, the
+		// latest seen timestamp for a data set remains the same across many
+		// successive queries, it's possible that the query window would grow to
+		// exceed the API maximum for this data set. Cap the window to prevent
+		// this.
+		lastDateTimeCounted = time.Now().UTC().Add(maxTimeWindow * -1)
+	}
+	lastSeenBucketTimes[tag] = lastDateTimeCounted
+	return lastDateTimeCounted
+}
 
-		var zonesFilter []string
-		if *cfZones != "" {
-			zonesFilter = strings.Split(*cfZones, ",")
+const combinedRequestKind = "graphql:zones:combined"
+
+// combinedZonesGqlQuery queries several datasets of one zone, or a batch of
+// zones, at once. Each dataset has its own start time variable, which are
+// returned in the order of the datasets.
+func combinedZonesGqlQuery(datasets []analyticsDataset, batch bool) (string, []string) {
+	var startTimeVars, selections []string
+	for _, dataset := range datasets {
+		startTimeVar := dataset.name + "_start_time"
+		startTimeVars = append(startTimeVars, startTimeVar)
+		selections = append(selections, strings.Replace(dataset.selection, "$start_time", "$"+startTimeVar, -1))
+	}
+	return zonesGqlQuery(batch, startTimeVars, selections), startTimeVars
+}
+
+// zoneAnalyticsRequest returns the request for datasets from some zones, each
+// dataset queried from its start time, and the zone name to report it under.
+// Batches are reported under an empty zone name, as they cover several.
+func (e *exporter) zoneAnalyticsRequest(
+	datasets []analyticsDataset, zones map[string]string, zoneIDs []string, startTimes []time.Time,
+) (*graphqlRequest, string) {
+	var req *graphqlRequest
+	switch {
+	case len(datasets) == 1 && len(zoneIDs) == 1:
+		req = datasets[0].req
+	case len(datasets) == 1:
+		req = newGraphqlRequest(zoneBatchGqlQuery(datasets[0].selection))
+	default:
+		query, startTimeVars := combinedZonesGqlQuery(datasets, len(zoneIDs) > 1)
+		req = newGraphqlRequest(query)
+		for i, startTimeVar := range startTimeVars {
+			req.Var(startTimeVar, startTimes[i])
 		}
+	}
+	if len(datasets) == 1 {
+		req.Var("start_time", startTimes[0])
+	}
+
+	if len(zoneIDs) == 1 {
+		req.Var("zone", zoneIDs[0])
+		return req, zones[zoneIDs[0]]
+	}
+	req.Var("zones", zoneIDs)
+	return req, ""
+}
+
+func (e *exporter) makeGraphqlRequest(
+	ctx context.Context, logger log.Logger, requestKind string, account *account, zoneName string,
+	req *graphqlRequest, resp interface{},
+) error {
+	if err := account.setAuthHeaders(req.Header); err != nil {
+		return err
+	}
+	if _, ok := req.vars["limit"]; !ok {
+		req.Var("limit", apiMaxLimit)
+	}
+	attempts := 0
+	duration, err := timeOperation(func() error {
+		return e.retryPolicy.do(ctx, logger, requestKind, func() error {
+			// The caller takes a token for the first attempt, before building
+			// the request. Retries count against the budget just the same.
+			if attempts++; attempts > 1 {
+				if err := account.queryBudget.take(ctx); err != nil {
+					return err
+				}
+			}
+			return observeAPIRequest(requestKind, account.name, zoneName, func() error {
+				return e.graphqlClient.Run(ctx, req, resp)
+			})
+		})
+	})
+	level.Debug(logger).Log("duration", duration.Seconds(), "msg", "finished request")
+	if errorKindOf(err) == errorKindRateLimited {
+		account.queryBudget.exhaust()
+	}
+	return err
+}
 
-		defer resp.Body.Close()
-		zones, err = parseZoneIDs(resp.Body, zonesFilter)
+// getZones lists the zones that an account's credentials can access and its
+// zone filter selects, recording how many there are before filtering.
+func (e *exporter) getZones(ctx context.Context, account *account) (map[string]string, error) {
+	zones := map[string]string{}
+	info, err := e.restClient.getPages(ctx, account, "rest:zones", "/zones", nil, zonesPerPage, func(result json.RawMessage) error {
+		pageZones, err := parseZoneIDs(result, account.zoneFilter)
 		if err != nil {
 			return err
 		}
+		for zoneID, zoneName := range pageZones {
+			zones[zoneID] = zoneName
+		}
 		return nil
 	})
-	level.Debug(e.logger).Log("request", "list zones", "duration", duration.Seconds(), "msg", "finished request")
-	return zones, err
+	if err != nil {
+		return nil, err
+	}
+	zonesListed.WithLabelValues(account.name).Set(float64(info.TotalCount))
+	return zones, nil
 }
 
 func newPromLogger(logLevel string) log.Logger {
@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -26,35 +30,127 @@ func init() {
 func TestParseZoneIDs(t *testing.T) {
 	for _, tc := range []struct {
 		name          string
-		zonesFilter   []string
+		filter        zoneFilterConfig
 		expectedZones map[string]string
 	}{
 		{
-			name:          "returns map of all non-pending zones when no filter specified",
-			expectedZones: map[string]string{"zone-1-id": "zone-1", "zone-2-id": "zone-2"},
+			name: "returns map of all non-pending zones when no filter specified",
+			expectedZones: map[string]string{
+				"zone-1-id": "zone-1", "zone-2-id": "zone-2", "zone-4-id": "staging.zone-4", "zone-5-id": "zone-5",
+			},
 		},
 		{
 			name:          "returns map of non-pending zones that are also present in filter",
-			zonesFilter:   []string{"zone-2"},
+			filter:        zoneFilterConfig{Names: []string{"zone-2"}},
+			expectedZones: map[string]string{"zone-2-id": "zone-2"},
+		},
+		{
+			name:          "returns zones selected by name or ID",
+			filter:        zoneFilterConfig{Names: []string{"zone-2"}, IDs: []string{"zone-5-id"}},
+			expectedZones: map[string]string{"zone-2-id": "zone-2", "zone-5-id": "zone-5"},
+		},
+		{
+			name:          "returns zones whose names match the include regex",
+			filter:        zoneFilterConfig{IncludeRegex: `^staging\.`},
+			expectedZones: map[string]string{"zone-4-id": "staging.zone-4"},
+		},
+		{
+			name:          "excludes zones whose names match the exclude regex",
+			filter:        zoneFilterConfig{IncludeRegex: `^zone-`, ExcludeRegex: `-[12]$`},
+			expectedZones: map[string]string{"zone-5-id": "zone-5"},
+		},
+		{
+			name:          "returns zones on the given plans",
+			filter:        zoneFilterConfig{Plans: []string{"pro", "enterprise"}},
+			expectedZones: map[string]string{"zone-4-id": "staging.zone-4", "zone-5-id": "zone-5"},
+		},
+		{
+			name:          "returns zones with the given statuses, treating paused zones as such",
+			filter:        zoneFilterConfig{Statuses: []string{"paused", "pending"}},
+			expectedZones: map[string]string{"zone-3-id": "zone-3", "zone-4-id": "staging.zone-4"},
+		},
+		{
+			name:          "returns zones that pass every filter",
+			filter:        zoneFilterConfig{Plans: []string{"free"}, Statuses: []string{"active"}, ExcludeRegex: `1`},
 			expectedZones: map[string]string{"zone-2-id": "zone-2"},
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
+			filter, err := newZoneFilter(tc.filter)
+			require.Nil(t, err)
 			f, err := os.Open("testdata/zones_resp.json")
 			require.Nil(t, err)
 			defer f.Close()
-			zones, err := parseZoneIDs(f, tc.zonesFilter)
+			var restResp restResponse
+			require.Nil(t, json.NewDecoder(f).Decode(&restResp))
+			zones, err := parseZoneIDs(restResp.Result, filter)
 			require.Nil(t, err)
-			assert.Equal(t, zones, tc.expectedZones)
+			assert.Equal(t, tc.expectedZones, zones)
 		})
 	}
 }
 
+func TestNewZoneFilter_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		filter zoneFilterConfig
+	}{
+		{name: "include regex", filter: zoneFilterConfig{IncludeRegex: "("}},
+		{name: "exclude regex", filter: zoneFilterConfig{ExcludeRegex: "["}},
+		{name: "plan", filter: zoneFilterConfig{Plans: []string{"platinum"}}},
+		{name: "status", filter: zoneFilterConfig{Statuses: []string{"asleep"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newZoneFilter(tc.filter)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestGetZones_RequestsAllPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/zones", r.URL.Path)
+		assert.Equal(t, "Bearer a-token", r.Header.Get("Authorization"))
+		http.ServeFile(w, r, fmt.Sprintf("testdata/zones_resp_page_%s.json", r.URL.Query().Get("page")))
+	}))
+	defer server.Close()
+
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	cfExporter := exporter{restClient: newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error"))}
+	zones, err := cfExporter.getZones(context.Background(), &account{name: "an-account", credentials: credentials{apiToken: "a-token"}})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"zone-1-id": "zone-1", "zone-2-id": "zone-2", "zone-4-id": "zone-4"}, zones)
+	// Zone 3 is filtered out, but still counted as listed.
+	assert.Equal(t, 4.0, testutil.ToFloat64(zonesListed.WithLabelValues("an-account")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(apiRequests.WithLabelValues("rest:zones", "an-account", "", "success")))
+}
+
+func TestGetZones_ClassifiesErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"success": false, "errors": [{"code": 9103, "message": "Unknown X-Auth-Key or X-Auth-Email"}]}`))
+	}))
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	cfExporter := exporter{restClient: newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error"))}
+	_, err := cfExporter.getZones(context.Background(), &account{name: "an-account"})
+	require.NotNil(t, err)
+	assert.Equal(t, errorKindAuthentication, errorKindOf(err))
+	assert.Equal(t, 1.0, testutil.ToFloat64(apiRequests.WithLabelValues("rest:zones", "an-account", "", "authentication")))
+}
+
 func TestZoneAnalytics(t *testing.T) {
 	for _, testCase := range []struct {
 		name                       string
 		metricsUnderTest           []string
 		lastUpdatedTime            string
+		enabledDatasets            map[string]bool
+		dnsQueryNames              map[string]bool
+		cacheStatusByContentType   bool
+		colos                      map[string]bool
+		colosByUpperTier           bool
 		apiRespFixturePaths        []string
 		expectedMetricsFixturePath string
 	}{
@@ -122,6 +218,85 @@ func TestZoneAnalytics(t *testing.T) {
 			apiRespFixturePaths:        []string{"health_check_events_resp.json"},
 			expectedMetricsFixturePath: "expected_health_check_events.metrics",
 		},
+		{
+			name:                       "sums load balancing requests for buckets later than specified time",
+			metricsUnderTest:           []string{"cloudflare_zones_load_balancing_requests_total"},
+			lastUpdatedTime:            "2020-02-12T07:37:00Z",
+			enabledDatasets:            map[string]bool{"loadBalancingRequestsAdaptiveGroups": true},
+			apiRespFixturePaths:        []string{"load_balancing_requests_resp.json"},
+			expectedMetricsFixturePath: "expected_load_balancing_requests.metrics",
+		},
+		{
+			name:                       "sums DNS queries for buckets later than specified time",
+			metricsUnderTest:           []string{"cloudflare_zones_dns_queries_total", "cloudflare_zones_dns_query_name_queries_total"},
+			lastUpdatedTime:            "2020-02-12T07:37:00Z",
+			enabledDatasets:            map[string]bool{"dnsAnalyticsAdaptiveGroups": true},
+			apiRespFixturePaths:        []string{"dns_analytics_resp.json"},
+			expectedMetricsFixturePath: "expected_dns_queries.metrics",
+		},
+		{
+			name:                       "sums DNS queries of listed names by name",
+			metricsUnderTest:           []string{"cloudflare_zones_dns_query_name_queries_total"},
+			lastUpdatedTime:            "2020-02-12T07:37:00Z",
+			enabledDatasets:            map[string]bool{"dnsAnalyticsAdaptiveGroups": true},
+			dnsQueryNames:              map[string]bool{"www.example.com": true, "example.com": true},
+			apiRespFixturePaths:        []string{"dns_analytics_resp.json"},
+			expectedMetricsFixturePath: "expected_dns_query_name_queries.metrics",
+		},
+		{
+			name: "sums HTTP requests by cache status for buckets later than specified time",
+			metricsUnderTest: []string{
+				"cloudflare_zones_http_cache_status_requests_total", "cloudflare_zones_http_cache_status_bytes_total",
+				"cloudflare_zones_http_content_type_cache_status_requests_total",
+			},
+			lastUpdatedTime:            "2020-02-12T07:37:00Z",
+			enabledDatasets:            map[string]bool{"httpRequestsAdaptiveCacheStatus": true},
+			apiRespFixturePaths:        []string{"http_cache_status_resp.json"},
+			expectedMetricsFixturePath: "expected_http_cache_status.metrics",
+		},
+		{
+			name:                       "sums HTTP requests by content type and cache status",
+			metricsUnderTest:           []string{"cloudflare_zones_http_content_type_cache_status_requests_total"},
+			lastUpdatedTime:            "2020-02-12T07:37:00Z",
+			enabledDatasets:            map[string]bool{"httpRequestsAdaptiveCacheStatus": true},
+			cacheStatusByContentType:   true,
+			apiRespFixturePaths:        []string{"http_cache_status_resp.json"},
+			expectedMetricsFixturePath: "expected_http_content_type_cache_status.metrics",
+		},
+		{
+			name: "sets HTTP latency quantiles of the latest bucket",
+			metricsUnderTest: []string{
+				"cloudflare_zones_http_edge_time_to_first_byte_seconds", "cloudflare_zones_http_origin_response_duration_seconds",
+				"cloudflare_zones_http_edge_dns_response_time_seconds",
+			},
+			lastUpdatedTime:            "2020-02-12T07:36:00Z",
+			enabledDatasets:            map[string]bool{"httpRequestsAdaptiveLatency": true},
+			apiRespFixturePaths:        []string{"http_latency_resp.json"},
+			expectedMetricsFixturePath: "expected_http_latency.metrics",
+		},
+		{
+			name: "sums HTTP requests by colo for buckets later than specified time",
+			metricsUnderTest: []string{
+				"cloudflare_zones_http_colo_requests_total", "cloudflare_zones_http_colo_bytes_total",
+				"cloudflare_zones_http_colo_5xx_responses_total", "cloudflare_zones_http_colo_upper_tier_requests_total",
+			},
+			lastUpdatedTime:            "2020-02-12T07:37:00Z",
+			enabledDatasets:            map[string]bool{"httpRequestsAdaptiveColos": true},
+			apiRespFixturePaths:        []string{"http_colos_resp.json"},
+			expectedMetricsFixturePath: "expected_http_colos.metrics",
+		},
+		{
+			name: "sums HTTP requests by listed colos and upper tier colos, counting others as other",
+			metricsUnderTest: []string{
+				"cloudflare_zones_http_colo_requests_total", "cloudflare_zones_http_colo_upper_tier_requests_total",
+			},
+			lastUpdatedTime:            "2020-02-12T07:37:00Z",
+			enabledDatasets:            map[string]bool{"httpRequestsAdaptiveColos": true},
+			colos:                      map[string]bool{"AMS": true, "SIN": true},
+			colosByUpperTier:           true,
+			apiRespFixturePaths:        []string{"http_colos_resp.json"},
+			expectedMetricsFixturePath: "expected_http_colos_upper_tier.metrics",
+		},
 	} {
 		t.Run(testCase.name, func(t *testing.T) {
 			reg := prometheus.NewPedanticRegistry()
@@ -130,18 +305,33 @@ func TestZoneAnalytics(t *testing.T) {
 			lastUpdatedTime, err := time.Parse(time.RFC3339, testCase.lastUpdatedTime)
 			require.Nil(t, err)
 
-			cfExporter := exporter{
-				logger:        newPromLogger("error"),
-				scrapeLock:    &sync.Mutex{},
-				graphqlClient: newFakeGraphqlClient(testCase.apiRespFixturePaths),
-				lastSeenBucketTimes: &lastUpdatedTimes{
-					httpReqsByZone:          map[string]time.Time{"a-zone": lastUpdatedTime},
-					firewallEventsByZone:    map[string]time.Time{"a-zone": lastUpdatedTime},
-					healthCheckEventsByZone: map[string]time.Time{"a-zone": lastUpdatedTime},
+			account := &account{
+				name: "an-account",
+				lastSeenBucketTimes: lastUpdatedTimes{
+					"httpRequests1mGroups":                {"a-zone": lastUpdatedTime},
+					"firewallEventsAdaptiveGroups":        {"a-zone": lastUpdatedTime},
+					"healthCheckEventsGroups":             {"a-zone": lastUpdatedTime},
+					"loadBalancingRequestsAdaptiveGroups": {"a-zone": lastUpdatedTime},
+					"dnsAnalyticsAdaptiveGroups":          {"a-zone": lastUpdatedTime},
+					"dnsAnalyticsAdaptiveQueryNames":      {"a-zone": lastUpdatedTime},
+					"httpRequestsAdaptiveCacheStatus":     {"a-zone": lastUpdatedTime},
+					"httpRequestsAdaptiveLatency":         {"a-zone": lastUpdatedTime},
+					"httpRequestsAdaptiveColos":           {"a-zone": lastUpdatedTime},
+					"httpRequestsAdaptiveColo5xx":         {"a-zone": lastUpdatedTime},
 				},
 			}
+			cfExporter := exporter{
+				logger:                   newPromLogger("error"),
+				scrapeLock:               &sync.Mutex{},
+				graphqlClient:            newFakeGraphqlClient(testCase.apiRespFixturePaths),
+				enabledDatasets:          testCase.enabledDatasets,
+				dnsQueryNames:            testCase.dnsQueryNames,
+				cacheStatusByContentType: testCase.cacheStatusByContentType,
+				colos:                    testCase.colos,
+				colosByUpperTier:         testCase.colosByUpperTier,
+			}
 			zones := map[string]string{"a-zone": "a-zone-name"}
-			require.Nil(t, cfExporter.getZoneAnalytics(context.Background(), zones))
+			require.Nil(t, cfExporter.getZoneAnalytics(context.Background(), account, zones))
 
 			fixture, err := os.Open(filepath.Join("testdata", testCase.expectedMetricsFixturePath))
 			require.Nil(t, err)
@@ -156,6 +346,410 @@ func TestZoneAnalytics(t *testing.T) {
 	}
 }
 
+func TestDNSQueryNamesDataset_FetchesListedNamesOnly(t *testing.T) {
+	cfExporter := exporter{
+		enabledDatasets: map[string]bool{"dnsAnalyticsAdaptiveGroups": true},
+		dnsQueryNames:   map[string]bool{"www.example.com": true, "example.com": true},
+	}
+	account := &account{name: "an-account"}
+	var names []string
+	for _, dataset := range cfExporter.zoneDatasets(account) {
+		names = append(names, dataset.name)
+	}
+	assert.Contains(t, names, "dnsAnalyticsAdaptiveQueryNames")
+	assert.Contains(t, cfExporter.dnsQueryNamesDataset(account).req.query, `queryName_in: ["example.com", "www.example.com"]`)
+
+	// Without any names, only the totals are queried.
+	cfExporter.dnsQueryNames = nil
+	names = nil
+	for _, dataset := range cfExporter.zoneDatasets(account) {
+		names = append(names, dataset.name)
+	}
+	assert.Contains(t, names, "dnsAnalyticsAdaptiveGroups")
+	assert.NotContains(t, names, "dnsAnalyticsAdaptiveQueryNames")
+}
+
+func TestDatasetQueries_OrdersByStaleness(t *testing.T) {
+	account := &account{
+		name: "an-account",
+		lastSeenBucketTimes: lastUpdatedTimes{
+			"httpRequests1mGroups":         {"zone-1": fixedTime, "zone-2": fixedTime.Add(-time.Minute)},
+			"firewallEventsAdaptiveGroups": {"zone-1": fixedTime.Add(-time.Hour), "zone-2": fixedTime},
+			"healthCheckEventsGroups":      {"zone-1": fixedTime, "zone-2": fixedTime},
+		},
+	}
+	cfExporter := exporter{}
+	var order []string
+	for _, query := range datasetQueries(cfExporter.zoneDatasets(account), map[string]string{"zone-1": "zone-1-name", "zone-2": "zone-2-name"}) {
+		order = append(order, query.dataset.requestKind+" "+query.tag)
+	}
+	assert.Equal(t, []string{
+		"graphql:zones:firewallEventsAdaptiveGroups zone-1",
+		"graphql:zones:httpRequests1mGroups zone-2",
+		"graphql:zones:firewallEventsAdaptiveGroups zone-2",
+		"graphql:zones:healthCheckEventsGroups zone-1",
+		"graphql:zones:healthCheckEventsGroups zone-2",
+		"graphql:zones:httpRequests1mGroups zone-1",
+	}, order)
+}
+
+func TestZoneAnalytics_DefersQueriesBeyondBudget(t *testing.T) {
+	registerMetrics(prometheus.NewPedanticRegistry())
+	cfExporter := exporter{
+		scrapeLock:    &sync.Mutex{},
+		logger:        newPromLogger("error"),
+		graphqlClient: newFakeGraphqlClient([]string{"http_reqs_resp.json"}),
+	}
+	// A budget that only refills once an hour, and has a single query to spend.
+	budget := newQueryBudget(1, time.Second)
+	budget.refillPerSecond = 1.0 / 3600
+	account := &account{name: "an-account", queryBudget: budget}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.Nil(t, cfExporter.getZoneAnalytics(ctx, account, map[string]string{"a-zone": "a-zone-name"}))
+	assert.Equal(t, 2.0, testutil.ToFloat64(graphqlQueriesDeferred.WithLabelValues("an-account")))
+}
+
+// flakyGraphqlClient fails its first requests with server errors.
+type flakyGraphqlClient struct {
+	failures, attempts int
+}
+
+func (g *flakyGraphqlClient) Run(context.Context, *graphqlRequest, interface{}) error {
+	g.attempts++
+	if g.attempts <= g.failures {
+		return &apiError{api: "graphql", kind: errorKindServer}
+	}
+	return nil
+}
+
+func TestMakeGraphqlRequest_ChargesRetriesToBudget(t *testing.T) {
+	registerMetrics(prometheus.NewPedanticRegistry())
+	// A budget that only refills once an hour.
+	budget := newQueryBudget(300, time.Minute)
+	budget.refillPerSecond = 1.0 / 3600
+	budget.tokens = 3
+	account := &account{name: "an-account", queryBudget: budget}
+	graphqlClient := &flakyGraphqlClient{failures: 2}
+	cfExporter := exporter{
+		scrapeLock:    &sync.Mutex{},
+		logger:        newPromLogger("error"),
+		graphqlClient: graphqlClient,
+		retryPolicy:   retryPolicy{maxAttempts: 5, baseDelay: time.Millisecond, maxDelay: time.Millisecond},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// The caller takes the first attempt's token, and each retry takes its own.
+	require.Nil(t, budget.take(ctx))
+	require.Nil(t, cfExporter.makeGraphqlRequest(
+		ctx, cfExporter.logger, "graphql:zones:httpRequests1mGroups", account, "a-zone", newGraphqlRequest("query {}"), nil,
+	))
+	assert.Equal(t, 3, graphqlClient.attempts)
+	assert.InDelta(t, 0.0, budget.remaining(), 0.01)
+
+	// Retries stop once the budget runs out.
+	graphqlClient = &flakyGraphqlClient{failures: 2}
+	cfExporter.graphqlClient = graphqlClient
+	budget.tokens = 1
+	require.Nil(t, budget.take(ctx))
+	err := cfExporter.makeGraphqlRequest(
+		ctx, cfExporter.logger, "graphql:zones:httpRequests1mGroups", account, "a-zone", newGraphqlRequest("query {}"), nil,
+	)
+	assert.Equal(t, errQueryBudgetExhausted, err)
+	assert.Equal(t, 1, graphqlClient.attempts)
+}
+
+func TestZoneAnalytics_BatchesZones(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	registerMetrics(reg)
+
+	aZoneLastUpdated := time.Date(2020, 2, 6, 10, 0, 0, 0, time.UTC)
+	bZoneLastUpdated := time.Date(2020, 2, 6, 10, 1, 0, 0, time.UTC)
+	graphqlClient := newFakeGraphqlClient([]string{"http_reqs_batch_resp.json"})
+	account := &account{
+		name: "an-account",
+		lastSeenBucketTimes: lastUpdatedTimes{
+			"httpRequests1mGroups": {"a-zone": aZoneLastUpdated, "b-zone": bZoneLastUpdated},
+		},
+	}
+	cfExporter := exporter{
+		scrapeLock:    &sync.Mutex{},
+		logger:        newPromLogger("error"),
+		graphqlClient: graphqlClient,
+		zoneBatchSize: 2,
+	}
+	zones := map[string]string{"a-zone": "a-zone-name", "b-zone": "b-zone-name"}
+	require.Nil(t, cfExporter.getZoneAnalytics(context.Background(), account, zones))
+
+	// One query per dataset, each covering both zones.
+	require.Len(t, graphqlClient.requests, 3)
+	var httpReqsReq *graphqlRequest
+	for _, req := range graphqlClient.requests {
+		assert.Contains(t, req.query, "zoneTag_in: $zones")
+		if strings.Contains(req.query, "httpRequests1mGroups") {
+			httpReqsReq = req
+		}
+	}
+	require.NotNil(t, httpReqsReq)
+	assert.Equal(t, []string{"a-zone", "b-zone"}, httpReqsReq.vars["zones"])
+	assert.Equal(t, aZoneLastUpdated.Add(-5*time.Minute), httpReqsReq.vars["start_time"])
+
+	// Only buckets after each zone's own last update are counted.
+	expected := `
+# HELP cloudflare_zones_http_cached_requests_total Number of cached HTTP requests served.
+# TYPE cloudflare_zones_http_cached_requests_total counter
+cloudflare_zones_http_cached_requests_total{account="an-account",zone="a-zone-name"} 6 1580983320000
+cloudflare_zones_http_cached_requests_total{account="an-account",zone="b-zone-name"} 40 1580983320000
+`
+	require.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "cloudflare_zones_http_cached_requests_total"))
+}
+
+func TestBatchZoneQueries_KeepsStalenessOrder(t *testing.T) {
+	httpReqs := analyticsDataset{requestKind: "http"}
+	firewallEvents := analyticsDataset{requestKind: "firewall"}
+	queries := []datasetQuery{
+		{httpReqs, "zone-1"}, {firewallEvents, "zone-1"}, {httpReqs, "zone-2"},
+		{httpReqs, "zone-3"}, {firewallEvents, "zone-2"}, {firewallEvents, "zone-3"},
+	}
+	describe := func(batches []*zoneQueryBatch) []string {
+		var described []string
+		for _, batch := range batches {
+			var requestKinds []string
+			for _, dataset := range batch.datasets {
+				requestKinds = append(requestKinds, dataset.requestKind)
+			}
+			described = append(described, strings.Join(requestKinds, "+")+" "+strings.Join(batch.zoneIDs, ","))
+		}
+		return described
+	}
+
+	assert.Equal(t, []string{
+		"http zone-1,zone-2", "firewall zone-1,zone-2", "http zone-3", "firewall zone-3",
+	}, describe(batchZoneQueries(queries, 2, false)))
+	assert.Equal(t, []string{
+		"http+firewall zone-1,zone-2", "http+firewall zone-3",
+	}, describe(batchZoneQueries(queries, 2, true)))
+}
+
+func TestZoneAnalytics_CombinesDatasets(t *testing.T) {
+	for _, testCase := range []struct {
+		name                string
+		apiRespFixturePaths []string
+		expectedQueries     int
+	}{
+		{
+			name:                "in one query",
+			apiRespFixturePaths: []string{"combined_resp.json"},
+			expectedQueries:     1,
+		},
+		{
+			name: "falling back to separate queries when the combined query fails",
+			// The combined response serves each of the separate queries too.
+			apiRespFixturePaths: []string{"combined_authz_error_resp.json", "combined_resp.json"},
+			expectedQueries:     4,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			registerMetrics(reg)
+
+			graphqlClient := newFakeGraphqlClient(testCase.apiRespFixturePaths)
+			account := &account{
+				name: "an-account",
+				lastSeenBucketTimes: lastUpdatedTimes{
+					"httpRequests1mGroups":         {"a-zone": time.Unix(0, 0).UTC()},
+					"firewallEventsAdaptiveGroups": {"a-zone": time.Date(2020, 2, 12, 7, 38, 0, 0, time.UTC)},
+					"healthCheckEventsGroups":      {"a-zone": time.Date(2020, 2, 12, 7, 0, 8, 0, time.UTC)},
+				},
+			}
+			cfExporter := exporter{
+				scrapeLock:          &sync.Mutex{},
+				logger:              newPromLogger("error"),
+				graphqlClient:       graphqlClient,
+				combineZoneDatasets: true,
+			}
+			zones := map[string]string{"a-zone": "a-zone-name"}
+			require.Nil(t, cfExporter.getZoneAnalytics(context.Background(), account, zones))
+
+			require.Len(t, graphqlClient.requests, testCase.expectedQueries)
+			combinedReq := graphqlClient.requests[0]
+			assert.Contains(t, combinedReq.query, "$firewallEventsAdaptiveGroups_start_time: Time!")
+			assert.Equal(t,
+				time.Date(2020, 2, 12, 7, 33, 0, 0, time.UTC), combinedReq.vars["firewallEventsAdaptiveGroups_start_time"],
+			)
+			assert.Equal(t,
+				time.Date(2020, 2, 12, 6, 55, 8, 0, time.UTC), combinedReq.vars["healthCheckEventsGroups_start_time"],
+			)
+
+			for fixturePath, metrics := range map[string][]string{
+				"expected_cache.metrics": {
+					"cloudflare_zones_http_cached_requests_total", "cloudflare_zones_http_cached_bytes_total",
+				},
+				"expected_firewall_events.metrics":     {"cloudflare_zones_firewall_events_total"},
+				"expected_health_check_events.metrics": {"cloudflare_zones_health_check_events_total"},
+			} {
+				fixture, err := os.Open(filepath.Join("testdata", fixturePath))
+				require.Nil(t, err)
+				err = testutil.GatherAndCompare(reg, fixture, metrics...)
+				fixture.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestZoneAnalytics_IsolatesFailures(t *testing.T) {
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	// Queries are made in order of dataset, then zone. The first, for a-zone's
+	// firewall events, is refused. The rest are answered with a-zone's HTTP
+	// requests, so that every query of b-zone is missing it.
+	cfExporter := exporter{
+		scrapeLock:    &sync.Mutex{},
+		logger:        newPromLogger("error"),
+		graphqlClient: newFakeGraphqlClient([]string{"combined_authz_error_resp.json", "http_reqs_resp.json"}),
+	}
+	zones := map[string]string{"a-zone": "a-zone-name", "b-zone": "b-zone-name"}
+	err := cfExporter.getZoneAnalytics(context.Background(), &account{name: "an-account"}, zones)
+	require.NotNil(t, err)
+	assert.Equal(t, errorKindAuthorization, errorKindOf(err))
+	assert.Contains(t, err.Error(), "4 errors")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		zoneScrapeErrs.WithLabelValues("an-account", "a-zone-name", "firewallEventsAdaptiveGroups", "authorization"),
+	))
+	for _, dataset := range []string{"firewallEventsAdaptiveGroups", "healthCheckEventsGroups", "httpRequests1mGroups"} {
+		assert.Equal(t, 1.0, testutil.ToFloat64(
+			zoneScrapeErrs.WithLabelValues("an-account", "b-zone-name", dataset, "unknown"),
+		), dataset)
+	}
+	for _, dataset := range []string{"healthCheckEventsGroups", "httpRequests1mGroups"} {
+		assert.InDelta(t, float64(time.Now().Unix()), testutil.ToFloat64(
+			zoneLastSuccessTimestampSeconds.WithLabelValues("an-account", "a-zone-name", dataset),
+		), 10, dataset)
+	}
+	assert.Equal(t, 2, testutil.CollectAndCount(zoneLastSuccessTimestampSeconds))
+}
+
+// pageGraphqlClient answers each query with the page of results for its start
+// time, recording the start times of queries of a dataset.
+type pageGraphqlClient struct {
+	pageFor    func(startTime time.Time) cloudflareResp
+	dataset    string
+	requests   int
+	startTimes []time.Time
+}
+
+func (g *pageGraphqlClient) Run(_ context.Context, req *graphqlRequest, respPtr interface{}) error {
+	startTime := req.vars["start_time"].(time.Time)
+	g.requests++
+	if strings.Contains(req.query, g.dataset+":") {
+		g.startTimes = append(g.startTimes, startTime)
+	}
+	*respPtr.(*cloudflareResp) = g.pageFor(startTime)
+	return nil
+}
+
+// coloPage returns a page of a-zone's httpRequestsAdaptiveColos results, with
+// the given number of groups from a minute bucket.
+func coloPage(t *testing.T, minute time.Time, groups int) cloudflareResp {
+	var coloGroups []map[string]interface{}
+	for i := 0; i < groups; i++ {
+		coloGroups = append(coloGroups, map[string]interface{}{
+			"count":      1,
+			"dimensions": map[string]string{"datetimeMinute": minute.Format(time.RFC3339)},
+		})
+	}
+	page, err := json.Marshal(map[string]interface{}{
+		"viewer": map[string]interface{}{
+			"zones": []map[string]interface{}{{"zoneTag": "a-zone", "httpRequestsAdaptiveColos": coloGroups}},
+		},
+	})
+	require.Nil(t, err)
+	var resp cloudflareResp
+	require.Nil(t, json.Unmarshal(page, &resp))
+	return resp
+}
+
+func TestZoneAnalytics_ResumesPagingWhenFullPageCountsNothing(t *testing.T) {
+	lastUpdatedTime := time.Now().UTC().Truncate(time.Minute).Add(-3 * time.Minute)
+	for _, testCase := range []struct {
+		name string
+		// pageFor answers queries of httpRequestsAdaptiveColos, and of the
+		// other datasets, which have no results in it.
+		pageFor         func(t *testing.T, startTime time.Time) cloudflareResp
+		expectedQueries []time.Time
+		expectedCounted time.Time
+	}{
+		{
+			// An overlapping query of a busy zone may return a full page of
+			// groups counted already. It is requested again without the
+			// overlap.
+			name: "overlap",
+			pageFor: func(t *testing.T, startTime time.Time) cloudflareResp {
+				if startTime.Before(lastUpdatedTime) {
+					return coloPage(t, lastUpdatedTime.Add(-time.Minute), apiMaxLimit)
+				}
+				return coloPage(t, lastUpdatedTime.Add(time.Minute), 1)
+			},
+			expectedQueries: []time.Time{lastUpdatedTime.Add(-5 * time.Minute), lastUpdatedTime},
+			expectedCounted: lastUpdatedTime.Add(time.Minute),
+		},
+		{
+			// A minute with more groups than fit in a page can never be paged
+			// through, and is skipped.
+			name: "busy minute",
+			pageFor: func(t *testing.T, startTime time.Time) cloudflareResp {
+				if startTime.Before(lastUpdatedTime.Add(time.Minute + 59*time.Second)) {
+					return coloPage(t, lastUpdatedTime.Add(time.Minute), apiMaxLimit)
+				}
+				return coloPage(t, lastUpdatedTime.Add(2*time.Minute), 1)
+			},
+			// Each query from the last second of a minute leaves out the rest
+			// of it.
+			expectedQueries: []time.Time{
+				lastUpdatedTime.Add(-5 * time.Minute), lastUpdatedTime, lastUpdatedTime.Add(59 * time.Second),
+				lastUpdatedTime.Add(time.Minute + 59*time.Second),
+			},
+			expectedCounted: lastUpdatedTime.Add(2 * time.Minute),
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			registerMetrics(prometheus.NewPedanticRegistry())
+			graphqlClient := &pageGraphqlClient{
+				pageFor: func(startTime time.Time) cloudflareResp { return testCase.pageFor(t, startTime) },
+				dataset: "httpRequestsAdaptiveColos",
+			}
+			account := &account{
+				name: "an-account",
+				lastSeenBucketTimes: lastUpdatedTimes{
+					"httpRequestsAdaptiveColos": {"a-zone": lastUpdatedTime},
+				},
+			}
+			cfExporter := exporter{
+				scrapeLock:      &sync.Mutex{},
+				logger:          newPromLogger("error"),
+				graphqlClient:   graphqlClient,
+				enabledDatasets: map[string]bool{"httpRequestsAdaptiveColos": true},
+			}
+			zones := map[string]string{"a-zone": "a-zone-name"}
+			require.Nil(t, cfExporter.getZoneAnalytics(context.Background(), account, zones))
+
+			assert.Equal(t, testCase.expectedQueries, graphqlClient.startTimes)
+			// The other datasets are up to date after a single query.
+			otherDatasets := len(cfExporter.zoneDatasets(account)) - 1
+			assert.Equal(t, otherDatasets+len(testCase.expectedQueries), graphqlClient.requests)
+			assert.Equal(t, testCase.expectedCounted, account.lastSeenBucketTimes["httpRequestsAdaptiveColos"]["a-zone"])
+			assert.Equal(t, 1, testutil.CollectAndCount(httpColoRequests))
+			assert.Equal(t, 0, testutil.CollectAndCount(zoneScrapeErrs))
+		})
+	}
+}
+
 func TestExtractZoneHTTPRequests_ReturnsUnmodifiedLastDateTimeCountedWhenNoDataReturned(t *testing.T) {
 	testDataFile, err := os.Open("testdata/empty_http_reqs_resp.json")
 	require.Nil(t, err)
@@ -166,8 +760,7 @@ func TestExtractZoneHTTPRequests_ReturnsUnmodifiedLastDateTimeCountedWhenNoDataR
 
 	lastDateTimeCounted := time.Now().UTC()
 
-	zones := map[string]string{"a-zone": "a-zone-name"}
-	_, newLastDateTime, err := extractZoneHTTPRequests(gqlResp["data"].Viewer.Zones[0], zones, lastDateTimeCounted)
+	_, newLastDateTime, err := extractZoneHTTPRequests("an-account", gqlResp["data"].Viewer.Zones[0], "a-zone-name", lastDateTimeCounted)
 	require.Nil(t, err)
 	assert.Equal(t, newLastDateTime, lastDateTimeCounted)
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentials authenticate requests to the Cloudflare APIs. Either a scoped
+// API token, or an account email address and global API key must be set. If
+// both are present, the API token takes precedence. The token and key may be
+// read from files instead.
+type credentials struct {
+	email        string
+	apiKey       string
+	apiToken     string
+	apiKeyFile   *credentialFile
+	apiTokenFile *credentialFile
+}
+
+func (c credentials) validate() error {
+	if (c.apiToken != "" && c.apiTokenFile != nil) || (c.apiKey != "" && c.apiKeyFile != nil) {
+		return errors.New("an API token or key cannot be set both directly and from a file")
+	}
+	for _, file := range []*credentialFile{c.apiTokenFile, c.apiKeyFile} {
+		if file == nil {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			return err
+		}
+	}
+
+	if c.usesAPIToken() {
+		return nil
+	}
+	if c.email == "" || (c.apiKey == "" && c.apiKeyFile == nil) {
+		return errors.New("no Cloudflare credentials: set an API token, or both an API email and API key")
+	}
+	return nil
+}
+
+func (c credentials) usesAPIToken() bool {
+	return c.apiToken != "" || c.apiTokenFile != nil
+}
+
+// setHeaders authenticates a request, reporting whether a credential file had
+// to be (re)loaded to do so.
+func (c credentials) setHeaders(header http.Header) (bool, error) {
+	// Requests may be reused across accounts, so clear headers that belong to
+	// the other style of authentication.
+	if c.usesAPIToken() {
+		apiToken, reloaded, err := readCredential(c.apiToken, c.apiTokenFile)
+		if err != nil {
+			return false, err
+		}
+		header.Del("X-AUTH-EMAIL")
+		header.Del("X-AUTH-KEY")
+		header.Set("Authorization", "Bearer "+apiToken)
+		return reloaded, nil
+	}
+
+	apiKey, reloaded, err := readCredential(c.apiKey, c.apiKeyFile)
+	if err != nil {
+		return false, err
+	}
+	header.Del("Authorization")
+	header.Set("X-AUTH-EMAIL", c.email)
+	header.Set("X-AUTH-KEY", apiKey)
+	return reloaded, nil
+}
+
+func readCredential(value string, file *credentialFile) (string, bool, error) {
+	if file == nil {
+		return value, false, nil
+	}
+	return file.read()
+}
+
+// credentialFile is a secret stored in a file, such as one written by Vault
+// agent. The file is stat'ed on every read, and its contents re-read whenever
+// its modification time or size changes, so that rotated credentials are used
+// by the next request without restarting the exporter.
+type credentialFile struct {
+	path string
+
+	lock    sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+// newCredentialFile returns nil for an empty path, meaning that the credential
+// is not read from a file.
+func newCredentialFile(path string) *credentialFile {
+	if path == "" {
+		return nil
+	}
+	return &credentialFile{path: path}
+}
+
+func (f *credentialFile) read() (string, bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", false, err
+	}
+	if f.value != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, false, nil
+	}
+
+	contents, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return "", false, err
+	}
+	value := strings.TrimSpace(string(contents))
+	if value == "" {
+		return "", false, fmt.Errorf("credential file %s is empty", f.path)
+	}
+	f.value, f.modTime, f.size = value, info.ModTime(), info.Size()
+	return f.value, true, nil
+}
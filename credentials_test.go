@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		creds           credentials
+		expectedErr     bool
+		expectedHeaders http.Header
+	}{
+		{
+			name:  "sends bearer token when an API token is set",
+			creds: credentials{apiToken: "a-token"},
+			expectedHeaders: http.Header{
+				"Authorization": []string{"Bearer a-token"},
+			},
+		},
+		{
+			name:  "sends email and API key when no API token is set",
+			creds: credentials{email: "a@example.com", apiKey: "a-key"},
+			expectedHeaders: http.Header{
+				"X-Auth-Email": []string{"a@example.com"},
+				"X-Auth-Key":   []string{"a-key"},
+			},
+		},
+		{
+			name:  "prefers the API token when both styles are set",
+			creds: credentials{email: "a@example.com", apiKey: "a-key", apiToken: "a-token"},
+			expectedHeaders: http.Header{
+				"Authorization": []string{"Bearer a-token"},
+			},
+		},
+		{
+			name:        "is invalid when an API token is set both directly and from a file",
+			creds:       credentials{apiToken: "a-token", apiTokenFile: newCredentialFile("testdata/zones_resp.json")},
+			expectedErr: true,
+		},
+		{
+			name:        "is invalid when a credential file does not exist",
+			creds:       credentials{apiTokenFile: newCredentialFile("testdata/does-not-exist")},
+			expectedErr: true,
+		},
+		{
+			name:        "is invalid when only an email is set",
+			creds:       credentials{email: "a@example.com"},
+			expectedErr: true,
+		},
+		{
+			name:        "is invalid when nothing is set",
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.creds.validate()
+			if tc.expectedErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+
+			header := http.Header{}
+			_, err = tc.creds.setHeaders(header)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedHeaders, header)
+		})
+	}
+}
+
+func TestCredentials_ReloadsChangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudflare_exporter")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "token")
+	require.Nil(t, ioutil.WriteFile(tokenPath, []byte("first-token\n"), 0600))
+
+	creds := credentials{apiTokenFile: newCredentialFile(tokenPath)}
+	require.Nil(t, creds.validate())
+
+	header := http.Header{}
+	reloaded, err := creds.setHeaders(header)
+	require.Nil(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "Bearer first-token", header.Get("Authorization"))
+
+	reloaded, err = creds.setHeaders(header)
+	require.Nil(t, err)
+	assert.False(t, reloaded)
+
+	require.Nil(t, ioutil.WriteFile(tokenPath, []byte("second-token\n"), 0600))
+	// Filesystem timestamps can be coarse, so make the change unambiguous.
+	later := time.Now().Add(time.Minute)
+	require.Nil(t, os.Chtimes(tokenPath, later, later))
+
+	reloaded, err = creds.setHeaders(header)
+	require.Nil(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "Bearer second-token", header.Get("Authorization"))
+}
+
+func TestCredentials_ClearsHeadersOfOtherStyle(t *testing.T) {
+	header := http.Header{}
+	_, err := credentials{apiToken: "a-token"}.setHeaders(header)
+	require.Nil(t, err)
+	_, err = credentials{email: "a@example.com", apiKey: "a-key"}.setHeaders(header)
+	require.Nil(t, err)
+	assert.Equal(t, http.Header{
+		"X-Auth-Email": []string{"a@example.com"},
+		"X-Auth-Key":   []string{"a-key"},
+	}, header)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errorKind classifies failed Cloudflare API requests, so that scrape errors
+// can be counted by cause and drive the backoff policy.
+type errorKind string
+
+const (
+	errorKindRateLimited    errorKind = "rate_limited"
+	errorKindAuthentication errorKind = "authentication"
+	errorKindAuthorization  errorKind = "authorization"
+	errorKindNotFound       errorKind = "not_found"
+	errorKindQueryInvalid   errorKind = "query_invalid"
+	errorKindServer         errorKind = "server_error"
+	errorKindUnknown        errorKind = "unknown"
+)
+
+var errorKinds = []errorKind{
+	errorKindRateLimited, errorKindAuthentication, errorKindAuthorization, errorKindNotFound,
+	errorKindQueryInvalid, errorKindServer, errorKindUnknown,
+}
+
+// backOff reports whether scrapes should be skipped after an error of this
+// kind. Only rate limiting is expected to clear up by itself if we give the
+// API some rest.
+func (k errorKind) backOff() bool {
+	return k == errorKindRateLimited
+}
+
+// apiError is a failed request to either the GraphQL or REST API.
+type apiError struct {
+	api        string
+	kind       errorKind
+	statusCode int
+	code       string
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	msg := e.message
+	if msg == "" {
+		msg = fmt.Sprintf("unexpected status %d", e.statusCode)
+	}
+	return fmt.Sprintf("%s: %s (%s)", e.api, msg, e.kind)
+}
+
+func errorKindOf(err error) errorKind {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.kind
+	}
+	return errorKindUnknown
+}
+
+// parseRetryAfter returns the delay requested by a Retry-After header, which
+// may be given in seconds or as an HTTP date. It returns zero if the header is
+// absent or invalid.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+func statusErrorKind(statusCode int) errorKind {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return errorKindRateLimited
+	case statusCode == http.StatusUnauthorized:
+		return errorKindAuthentication
+	case statusCode == http.StatusForbidden:
+		return errorKindAuthorization
+	case statusCode == http.StatusNotFound:
+		return errorKindNotFound
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity:
+		return errorKindQueryInvalid
+	case statusCode >= 500:
+		return errorKindServer
+	default:
+		return errorKindUnknown
+	}
+}
+
+var graphqlErrorCodeKinds = map[string]errorKind{
+	"ratelimited":             errorKindRateLimited,
+	"budgetdepleted":          errorKindRateLimited,
+	"toomanyrequests":         errorKindRateLimited,
+	"unauthenticated":         errorKindAuthentication,
+	"unauthorized":            errorKindAuthentication,
+	"authn":                   errorKindAuthentication,
+	"authentication":          errorKindAuthentication,
+	"forbidden":               errorKindAuthorization,
+	"authz":                   errorKindAuthorization,
+	"authorization":           errorKindAuthorization,
+	"accessdenied":            errorKindAuthorization,
+	"notfound":                errorKindNotFound,
+	"graphqlparsefailed":      errorKindQueryInvalid,
+	"graphqlvalidationfailed": errorKindQueryInvalid,
+	"baduserinput":            errorKindQueryInvalid,
+	"badrequest":              errorKindQueryInvalid,
+	"parse":                   errorKindQueryInvalid,
+	"validation":              errorKindQueryInvalid,
+	"internalservererror":     errorKindServer,
+	"internal":                errorKindServer,
+	"servererror":             errorKindServer,
+}
+
+// Cloudflare does not always set an error code, so a few messages observed in
+// the wild are recognised too:
+//   - "rate limiter budget depleted, please try again later"
+//   - "limit reached, please try again later"
+//   - "zone '...' does not have access to the path"
+var graphqlErrorMessageKinds = []struct {
+	substring string
+	kind      errorKind
+}{
+	{"rate limiter budget depleted", errorKindRateLimited},
+	{"limit reached, please try again later", errorKindRateLimited},
+	{"does not have access", errorKindAuthorization},
+}
+
+func graphqlErrorKind(code, message string) errorKind {
+	normalisedCode := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(code))
+	if kind, ok := graphqlErrorCodeKinds[normalisedCode]; ok {
+		return kind
+	}
+	for _, messageKind := range graphqlErrorMessageKinds {
+		if strings.Contains(strings.ToLower(message), messageKind.substring) {
+			return messageKind.kind
+		}
+	}
+	return errorKindUnknown
+}
+
+// https://api.cloudflare.com/#getting-started-responses
+var restErrorCodeKinds = map[int]errorKind{
+	971:   errorKindRateLimited,
+	6003:  errorKindAuthentication,
+	6103:  errorKindAuthentication,
+	6111:  errorKindAuthentication,
+	9103:  errorKindAuthentication,
+	9106:  errorKindAuthentication,
+	10000: errorKindAuthentication,
+	10001: errorKindAuthentication,
+	9109:  errorKindAuthorization,
+	1001:  errorKindNotFound,
+	7000:  errorKindNotFound,
+	7003:  errorKindNotFound,
+}
+
+type restError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newRESTError(statusCode int, restErrors []restError) *apiError {
+	apiErr := &apiError{api: "rest", kind: statusErrorKind(statusCode), statusCode: statusCode}
+	if len(restErrors) > 0 {
+		apiErr.code = toString(restErrors[0].Code)
+		apiErr.message = restErrors[0].Message
+	}
+	for _, restErr := range restErrors {
+		if kind, ok := restErrorCodeKinds[restErr.Code]; ok {
+			apiErr.kind = kind
+			apiErr.code = toString(restErr.Code)
+			apiErr.message = restErr.Message
+			break
+		}
+	}
+	return apiErr
+}
+
+// scrapeErrors collects the failures of a scrape that carries on past them.
+type scrapeErrors []error
+
+func (errs *scrapeErrors) add(err error) {
+	*errs = append(*errs, err)
+}
+
+// err returns nil if there were no failures, or else an error summarising
+// them.
+func (errs scrapeErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &aggregateError{errs: errs}
+}
+
+type aggregateError struct {
+	errs []error
+}
+
+func (e *aggregateError) Error() string {
+	if len(e.errs) == 1 {
+		return e.errs[0].Error()
+	}
+	return fmt.Sprintf("%d errors, first: %s", len(e.errs), e.errs[0])
+}
+
+// Unwrap returns the first rate limiting error if there is one, as that kind
+// drives backoff, and otherwise the first error.
+func (e *aggregateError) Unwrap() error {
+	for _, err := range e.errs {
+		if errorKindOf(err) == errorKindRateLimited {
+			return err
+		}
+	}
+	return e.errs[0]
+}
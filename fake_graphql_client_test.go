@@ -5,30 +5,47 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
-
-	"github.com/machinebox/graphql"
 )
 
+// fakeGraphqlClient serves responses from fixtures in turn, repeating the last
+// one for any further requests.
 type fakeGraphqlClient struct {
 	responseFixturePaths []string
 	reqIdx               int
+	// Copies of the requests made, as requests are reused between queries.
+	requests []*graphqlRequest
 }
 
 func newFakeGraphqlClient(responseFixturePaths []string) *fakeGraphqlClient {
 	return &fakeGraphqlClient{responseFixturePaths: responseFixturePaths}
 }
 
-func (g *fakeGraphqlClient) Run(_ context.Context, _ *graphql.Request, respPtr interface{}) error {
+func (g *fakeGraphqlClient) Run(_ context.Context, req *graphqlRequest, respPtr interface{}) error {
+	reqCopy := newGraphqlRequest(req.query)
+	for key, value := range req.vars {
+		reqCopy.Var(key, value)
+	}
+	g.requests = append(g.requests, reqCopy)
+
 	responseFixture, err := os.Open(filepath.Join("testdata", g.responseFixturePaths[g.reqIdx]))
 	if err != nil {
 		return err
 	}
 	defer responseFixture.Close()
-	var wrappedResponse map[string]interface{}
+	if g.reqIdx < len(g.responseFixturePaths)-1 {
+		g.reqIdx++
+	}
+	var wrappedResponse struct {
+		Data   interface{}    `json:"data"`
+		Errors []graphqlError `json:"errors"`
+	}
 	if err := json.NewDecoder(responseFixture).Decode(&wrappedResponse); err != nil {
 		return err
 	}
-	unwrappedSerialisedResp, err := json.Marshal(wrappedResponse["data"])
+	if len(wrappedResponse.Errors) > 0 {
+		return newGraphqlError(wrappedResponse.Errors)
+	}
+	unwrappedSerialisedResp, err := json.Marshal(wrappedResponse.Data)
 	if err != nil {
 		return err
 	}
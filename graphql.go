@@ -1,14 +1,15 @@
 package main
 
-import "github.com/machinebox/graphql"
-
-var (
-	httpReqsGqlReq = graphql.NewRequest(`
-query ($zone: String!, $start_time: Time!, $limit: Int!) {
-  viewer {
-    zones(filter: {zoneTag: $zone}) {
-      zoneTag
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
+// Selections of zone-scoped datasets, wrapped by zoneGqlQuery or
+// zoneBatchGqlQuery to query one or many zones at once.
+const (
+	httpReqsGqlSelection = `
       httpRequests1mGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetime_ASC]) {
         sum {
           countryMap {
@@ -35,16 +36,9 @@ query ($zone: String!, $start_time: Time!, $limit: Int!) {
         dimensions {
           datetime
         }
-      }
-    }
-  }
-}
-	`)
+      }`
 
-	firewallEventsGqlReq = graphql.NewRequest(`
-query ($zone: String!, $start_time: Time!, $limit: Int!) {
-  viewer {
-    zones(filter: {zoneTag: $zone}) {
+	firewallEventsGqlSelection = `
       firewallEventsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time, action_neq: "log"}, orderBy: [datetime_ASC]) {
         count
         dimensions {
@@ -55,17 +49,9 @@ query ($zone: String!, $start_time: Time!, $limit: Int!) {
           ruleId
           source
         }
-      }
-      zoneTag
-    }
-  }
-}
-	`)
+      }`
 
-	healthCheckEventsGqlReq = graphql.NewRequest(`
-query ($zone: String!, $start_time: Time!, $limit: Int!) {
-  viewer {
-    zones(filter: {zoneTag: $zone}) {
+	healthCheckEventsGqlSelection = `
       healthCheckEventsGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetime_ASC]) {
         count
         dimensions {
@@ -77,10 +63,218 @@ query ($zone: String!, $start_time: Time!, $limit: Int!) {
           originResponseStatus
           datetime
         }
-      }
+      }`
+
+	loadBalancingRequestsGqlSelection = `
+      loadBalancingRequestsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetimeMinute_ASC]) {
+        count
+        dimensions {
+          datetimeMinute
+          lbName
+          selectedPoolName
+          selectedOriginName
+          steeringPolicy
+          isFallbackUsed
+        }
+      }`
+
+	dnsAnalyticsGqlSelection = `
+      dnsAnalyticsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetimeMinute_ASC]) {
+        count
+        dimensions {
+          datetimeMinute
+          queryType
+          responseCode
+        }
+      }`
+
+	// The cache status of HTTP requests is queried from the adaptive HTTP
+	// dataset under an alias, as other breakdowns of it are too.
+	httpCacheStatusGqlSelection = `
+      httpRequestsAdaptiveCacheStatus: httpRequestsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetimeMinute_ASC]) {
+        count
+        sum {
+          edgeResponseBytes
+        }
+        dimensions {
+          datetimeMinute
+          cacheStatus
+        }
+      }`
+
+	httpCacheStatusContentTypeGqlSelection = `
+      httpRequestsAdaptiveCacheStatus: httpRequestsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetimeMinute_ASC]) {
+        count
+        sum {
+          edgeResponseBytes
+        }
+        dimensions {
+          datetimeMinute
+          cacheStatus
+          edgeResponseContentTypeName
+        }
+      }`
+
+	httpLatencyGqlSelection = `
+      httpRequestsAdaptiveLatency: httpRequestsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetimeMinute_ASC]) {
+        quantiles {
+          edgeTimeToFirstByteMsP50
+          edgeTimeToFirstByteMsP90
+          edgeTimeToFirstByteMsP99
+          originResponseDurationMsP50
+          originResponseDurationMsP90
+          originResponseDurationMsP99
+          edgeDnsResponseTimeMsP50
+          edgeDnsResponseTimeMsP90
+          edgeDnsResponseTimeMsP99
+        }
+        dimensions {
+          datetimeMinute
+        }
+      }`
+
+	httpColosGqlSelection = `
+      httpRequestsAdaptiveColos: httpRequestsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetimeMinute_ASC]) {
+        count
+        sum {
+          edgeResponseBytes
+        }
+        dimensions {
+          datetimeMinute
+          coloCode
+        }
+      }`
+
+	httpColosUpperTierGqlSelection = `
+      httpRequestsAdaptiveColos: httpRequestsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetimeMinute_ASC]) {
+        count
+        sum {
+          edgeResponseBytes
+        }
+        dimensions {
+          datetimeMinute
+          coloCode
+          upperTierColoName
+        }
+      }`
+
+	// Grouping requests by status as well as colo would return more groups
+	// per minute than fit in a page for busy zones, so 5xx responses are
+	// queried separately.
+	httpColo5xxGqlSelection = `
+      httpRequestsAdaptiveColo5xx: httpRequestsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time, edgeResponseStatus_geq: 500}, orderBy: [datetimeMinute_ASC]) {
+        count
+        dimensions {
+          datetimeMinute
+          coloCode
+        }
+      }`
+)
+
+// Selections of account-scoped datasets, wrapped by accountGqlQuery.
+const (
+	workersInvocationsGqlSelection = `
+      workersInvocationsAdaptive(limit: $limit, filter: {datetime_gt: $start_time}, orderBy: [datetimeMinute_ASC]) {
+        sum {
+          requests
+          errors
+          subrequests
+        }
+        quantiles {
+          cpuTimeP50
+          cpuTimeP99
+          wallTimeP50
+          wallTimeP99
+        }
+        dimensions {
+          datetimeMinute
+          scriptName
+          status
+        }
+      }`
+)
+
+var (
+	httpReqsGqlReq                   = newGraphqlRequest(zoneGqlQuery(httpReqsGqlSelection))
+	firewallEventsGqlReq             = newGraphqlRequest(zoneGqlQuery(firewallEventsGqlSelection))
+	healthCheckEventsGqlReq          = newGraphqlRequest(zoneGqlQuery(healthCheckEventsGqlSelection))
+	loadBalancingRequestsGqlReq      = newGraphqlRequest(zoneGqlQuery(loadBalancingRequestsGqlSelection))
+	dnsAnalyticsGqlReq               = newGraphqlRequest(zoneGqlQuery(dnsAnalyticsGqlSelection))
+	httpCacheStatusGqlReq            = newGraphqlRequest(zoneGqlQuery(httpCacheStatusGqlSelection))
+	httpCacheStatusContentTypeGqlReq = newGraphqlRequest(zoneGqlQuery(httpCacheStatusContentTypeGqlSelection))
+	httpLatencyGqlReq                = newGraphqlRequest(zoneGqlQuery(httpLatencyGqlSelection))
+	httpColosGqlReq                  = newGraphqlRequest(zoneGqlQuery(httpColosGqlSelection))
+	httpColosUpperTierGqlReq         = newGraphqlRequest(zoneGqlQuery(httpColosUpperTierGqlSelection))
+	httpColo5xxGqlReq                = newGraphqlRequest(zoneGqlQuery(httpColo5xxGqlSelection))
+
+	workersInvocationsGqlReq = newGraphqlRequest(accountGqlQuery(workersInvocationsGqlSelection))
+)
+
+// dnsQueryNamesGqlSelection selects DNS queries of the given names only, by name
+// and response code. Grouping every name queried would return too many groups
+// per minute to page through during random subdomain floods.
+func dnsQueryNamesGqlSelection(queryNames []string) string {
+	var quoted []string
+	for _, queryName := range queryNames {
+		quoted = append(quoted, strconv.Quote(queryName))
+	}
+	return fmt.Sprintf(`
+      dnsAnalyticsAdaptiveQueryNames: dnsAnalyticsAdaptiveGroups(limit: $limit, filter: {datetime_gt: $start_time, queryName_in: [%s]}, orderBy: [datetimeMinute_ASC]) {
+        count
+        dimensions {
+          datetimeMinute
+          queryName
+          responseCode
+        }
+      }`, strings.Join(quoted, ", "))
+}
+
+func zoneGqlQuery(selection string) string {
+	return zonesGqlQuery(false, []string{"start_time"}, []string{selection})
+}
+
+// zoneBatchGqlQuery queries several zones at once. $limit applies to each zone
+// separately.
+func zoneBatchGqlQuery(selection string) string {
+	return zonesGqlQuery(true, []string{"start_time"}, []string{selection})
+}
+
+// zonesGqlQuery wraps selections in a query of a single zone ($zone), or a
+// batch of zones ($zones). Each of startTimeVars is declared for use by the
+// selections.
+func zonesGqlQuery(batch bool, startTimeVars []string, selections []string) string {
+	zoneVar, zoneFilter := "$zone: String!", "zoneTag: $zone"
+	if batch {
+		zoneVar, zoneFilter = "$zones: [String!]!", "zoneTag_in: $zones"
+	}
+	vars := []string{zoneVar}
+	for _, startTimeVar := range startTimeVars {
+		vars = append(vars, fmt.Sprintf("$%s: Time!", startTimeVar))
+	}
+	vars = append(vars, "$limit: Int!")
+	return fmt.Sprintf(`
+query (%s) {
+  viewer {
+    zones(filter: {%s}) {
       zoneTag
+%s
     }
   }
 }
-	`)
-)
+	`, strings.Join(vars, ", "), zoneFilter, strings.Join(selections, "\n"))
+}
+
+// accountGqlQuery wraps the selection of an account-scoped dataset in a query
+// of a single Cloudflare account ($account).
+func accountGqlQuery(selection string) string {
+	return fmt.Sprintf(`
+query ($account: String!, $start_time: Time!, $limit: Int!) {
+  viewer {
+    accounts(filter: {accountTag: $account}) {
+      accountTag
+%s
+    }
+  }
+}
+	`, selection)
+}
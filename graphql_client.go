@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/machinebox/graphql"
+)
+
+// graphqlRequest is a query and its variables. Unlike *graphql.Request, they
+// can be read back, to page and to log requests.
+type graphqlRequest struct {
+	query  string
+	vars   map[string]interface{}
+	Header http.Header
+}
+
+func newGraphqlRequest(query string) *graphqlRequest {
+	return &graphqlRequest{query: query, vars: map[string]interface{}{}, Header: http.Header{}}
+}
+
+func (r *graphqlRequest) Var(key string, value interface{}) {
+	r.vars[key] = value
+}
+
+type graphqlClient interface {
+	Run(context.Context, *graphqlRequest, interface{}) error
+}
+
+// cloudflareGraphqlClient sends queries to the Cloudflare analytics API. Its
+// HTTP client classifies failures by HTTP status and GraphQL error extensions,
+// which *graphql.Client otherwise reduces to a message, and they are returned
+// as *apiError.
+type cloudflareGraphqlClient struct {
+	client *graphql.Client
+}
+
+func newGraphqlClient(endpoint string, httpClient *http.Client) *cloudflareGraphqlClient {
+	classifyingClient := *httpClient
+	classifyingClient.Transport = graphqlErrorTransport{next: httpClient.Transport}
+	return &cloudflareGraphqlClient{client: graphql.NewClient(endpoint, graphql.WithHTTPClient(&classifyingClient))}
+}
+
+func (c *cloudflareGraphqlClient) Run(ctx context.Context, req *graphqlRequest, resp interface{}) error {
+	gqlReq := graphql.NewRequest(req.query)
+	for key, value := range req.vars {
+		gqlReq.Var(key, value)
+	}
+	for key, values := range req.Header {
+		for _, value := range values {
+			gqlReq.Header.Add(key, value)
+		}
+	}
+	err := c.client.Run(ctx, gqlReq, resp)
+	// The HTTP client wraps the errors of its transport in *url.Error.
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return err
+}
+
+type graphqlError struct {
+	Message    string        `json:"message"`
+	Path       []interface{} `json:"path"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+// graphqlErrorTransport turns responses that are not successful, or that
+// report GraphQL errors, into *apiError.
+type graphqlErrorTransport struct {
+	// next defaults to http.DefaultTransport if nil.
+	next http.RoundTripper
+}
+
+func (t graphqlErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var gqlResp struct {
+		Errors []graphqlError `json:"errors"`
+	}
+	decodeErr := json.Unmarshal(body, &gqlResp)
+	if resp.StatusCode != http.StatusOK {
+		apiErr := newGraphqlError(gqlResp.Errors)
+		apiErr.statusCode = resp.StatusCode
+		apiErr.retryAfter = parseRetryAfter(resp.Header, time.Now())
+		if apiErr.kind == errorKindUnknown {
+			apiErr.kind = statusErrorKind(resp.StatusCode)
+		}
+		return nil, apiErr
+	}
+	if decodeErr == nil && len(gqlResp.Errors) > 0 {
+		return nil, newGraphqlError(gqlResp.Errors)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// newGraphqlError summarises a list of GraphQL errors by the first of them,
+// unless any indicate rate limiting, as that determines whether we back off.
+func newGraphqlError(gqlErrors []graphqlError) *apiError {
+	apiErr := &apiError{api: "graphql", kind: errorKindUnknown}
+	for i, gqlErr := range gqlErrors {
+		kind := graphqlErrorKind(gqlErr.Extensions.Code, gqlErr.Message)
+		if i == 0 || kind == errorKindRateLimited {
+			apiErr.kind = kind
+			apiErr.code = gqlErr.Extensions.Code
+			apiErr.message = gqlErr.Message
+		}
+		if kind == errorKindRateLimited {
+			break
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphqlClient_SendsQueryAndDecodesData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer a-token", r.Header.Get("Authorization"))
+		var body struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "query { viewer { zones { zoneTag } } }", body.Query)
+		assert.Equal(t, map[string]interface{}{"zone": "a-zone"}, body.Variables)
+		_, _ = w.Write([]byte(`{"data": {"viewer": {"zones": [{"zoneTag": "a-zone"}]}}}`))
+	}))
+	defer server.Close()
+
+	req := newGraphqlRequest("query { viewer { zones { zoneTag } } }")
+	req.Var("zone", "a-zone")
+	req.Header.Set("Authorization", "Bearer a-token")
+	var resp cloudflareResp
+	require.Nil(t, newGraphqlClient(server.URL, http.DefaultClient).Run(context.Background(), req, &resp))
+	require.Len(t, resp.Viewer.Zones, 1)
+	assert.Equal(t, "a-zone", resp.Viewer.Zones[0].ZoneTag)
+}
+
+func TestGraphqlClient_ClassifiesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		statusCode   int
+		body         string
+		expectedKind errorKind
+	}{
+		{
+			name:         "rate limited by status code",
+			statusCode:   http.StatusTooManyRequests,
+			expectedKind: errorKindRateLimited,
+		},
+		{
+			name:         "rate limited by error message",
+			statusCode:   http.StatusOK,
+			body:         `{"data": null, "errors": [{"message": "rate limiter budget depleted, please try again later"}]}`,
+			expectedKind: errorKindRateLimited,
+		},
+		{
+			name:         "rate limited by later error",
+			statusCode:   http.StatusOK,
+			body:         `{"data": null, "errors": [{"message": "something"}, {"message": "limit reached, please try again later"}]}`,
+			expectedKind: errorKindRateLimited,
+		},
+		{
+			name:         "not rate limited when the message mentions the limit variable",
+			statusCode:   http.StatusOK,
+			body:         `{"data": null, "errors": [{"message": "variable $limit is invalid", "extensions": {"code": "GRAPHQL_VALIDATION_FAILED"}}]}`,
+			expectedKind: errorKindQueryInvalid,
+		},
+		{
+			name:         "authentication by status code",
+			statusCode:   http.StatusUnauthorized,
+			body:         `not json`,
+			expectedKind: errorKindAuthentication,
+		},
+		{
+			name:         "authorization by extension code",
+			statusCode:   http.StatusOK,
+			body:         `{"data": null, "errors": [{"message": "not allowed", "extensions": {"code": "authz"}}]}`,
+			expectedKind: errorKindAuthorization,
+		},
+		{
+			name:         "server error by status code",
+			statusCode:   http.StatusBadGateway,
+			expectedKind: errorKindServer,
+		},
+		{
+			name:         "unknown",
+			statusCode:   http.StatusOK,
+			body:         `{"data": null, "errors": [{"message": "something odd"}]}`,
+			expectedKind: errorKindUnknown,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(tc.statusCode)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			var resp cloudflareResp
+			err := newGraphqlClient(server.URL, http.DefaultClient).Run(context.Background(), newGraphqlRequest("query {}"), &resp)
+			require.NotNil(t, err)
+			assert.IsType(t, &apiError{}, err)
+			assert.Equal(t, tc.expectedKind, errorKindOf(err))
+		})
+	}
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// gqlIntrospectionQuery fetches just enough of the schema to check the fields
+// that queries select, and the arguments they pass.
+const gqlIntrospectionQuery = `
+query {
+  __schema {
+    queryType {
+      name
+    }
+    types {
+      name
+      kind
+      fields(includeDeprecated: true) {
+        name
+        isDeprecated
+        deprecationReason
+        args {
+          name
+          type {
+            ...TypeRef
+          }
+        }
+        type {
+          ...TypeRef
+        }
+      }
+      inputFields {
+        name
+        type {
+          ...TypeRef
+        }
+      }
+      enumValues(includeDeprecated: true) {
+        name
+        isDeprecated
+        deprecationReason
+      }
+    }
+  }
+}
+
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType {
+    kind
+    name
+    ofType {
+      kind
+      name
+      ofType {
+        kind
+        name
+      }
+    }
+  }
+}
+`
+
+type gqlSchema struct {
+	QueryType struct {
+		Name string `json:"name"`
+	} `json:"queryType"`
+	Types []gqlType `json:"types"`
+}
+
+type gqlType struct {
+	Name        string           `json:"name"`
+	Kind        string           `json:"kind"`
+	Fields      []gqlSchemaField `json:"fields"`
+	InputFields []gqlInputValue  `json:"inputFields"`
+	EnumValues  []gqlEnumValue   `json:"enumValues"`
+}
+
+type gqlSchemaField struct {
+	Name              string          `json:"name"`
+	IsDeprecated      bool            `json:"isDeprecated"`
+	DeprecationReason string          `json:"deprecationReason"`
+	Args              []gqlInputValue `json:"args"`
+	Type              gqlTypeRef      `json:"type"`
+}
+
+// gqlInputValue is an argument of a field, or a field of an input object.
+type gqlInputValue struct {
+	Name string     `json:"name"`
+	Type gqlTypeRef `json:"type"`
+}
+
+type gqlEnumValue struct {
+	Name              string `json:"name"`
+	IsDeprecated      bool   `json:"isDeprecated"`
+	DeprecationReason string `json:"deprecationReason"`
+}
+
+type gqlTypeRef struct {
+	Kind   string      `json:"kind"`
+	Name   string      `json:"name"`
+	OfType *gqlTypeRef `json:"ofType"`
+}
+
+// namedType unwraps lists and non-null types.
+func (t gqlTypeRef) namedType() string {
+	for t.OfType != nil {
+		t = *t.OfType
+	}
+	return t.Name
+}
+
+// parseGqlSchema decodes an introspection response.
+func parseGqlSchema(r io.Reader) (*gqlSchema, error) {
+	var resp struct {
+		Data struct {
+			Schema gqlSchema `json:"__schema"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data.Schema, nil
+}
+
+// validate checks that every field a query selects, and every argument it
+// passes, exists in the schema, returning the deprecated fields and enum values
+// that it uses. Arguments given as variables are not checked.
+func (s *gqlSchema) validate(query string) ([]string, error) {
+	selections, err := parseGqlSelections(query)
+	if err != nil {
+		return nil, err
+	}
+	types := map[string]gqlType{}
+	for _, t := range s.Types {
+		types[t.Name] = t
+	}
+
+	var deprecations, problems []string
+	var checkValue func(path string, typeRef gqlTypeRef, value gqlValue)
+	checkValue = func(path string, typeRef gqlTypeRef, value gqlValue) {
+		if value.variable != "" {
+			return
+		}
+		for typeRef.Kind == "NON_NULL" && typeRef.OfType != nil {
+			typeRef = *typeRef.OfType
+		}
+		if typeRef.Kind == "LIST" && typeRef.OfType != nil {
+			if value.list == nil {
+				// A single value is accepted as a list of one.
+				checkValue(path, *typeRef.OfType, value)
+			}
+			for _, element := range value.list {
+				checkValue(path, *typeRef.OfType, element)
+			}
+			return
+		}
+		t := types[typeRef.Name]
+		switch t.Kind {
+		case "INPUT_OBJECT":
+			if value.object == nil {
+				problems = append(problems, fmt.Sprintf("%s: expected an input object of type %s", path, t.Name))
+				return
+			}
+			for _, field := range value.object {
+				inputField, ok := t.inputField(field.name)
+				if !ok {
+					problems = append(problems, fmt.Sprintf("%s: no input field %s on type %s", path, field.name, t.Name))
+					continue
+				}
+				checkValue(path+"."+field.name, inputField.Type, field.value)
+			}
+		case "ENUM":
+			enumValue, ok := t.enumValue(value.literal)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: no value %s in enum %s", path, value.literal, t.Name))
+				return
+			}
+			if enumValue.IsDeprecated {
+				deprecations = append(deprecations, fmt.Sprintf("%s: %s: %s", path, enumValue.Name, enumValue.DeprecationReason))
+			}
+		}
+	}
+	var walk func(typeName, path string, selections []gqlField)
+	walk = func(typeName, path string, selections []gqlField) {
+		t, ok := types[typeName]
+		if !ok || len(t.Fields) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: type %s has no fields to select", path, typeName))
+			return
+		}
+		for _, selection := range selections {
+			fieldPath := strings.TrimPrefix(path+"."+selection.name, ".")
+			field, ok := t.field(selection.name)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: no field %s on type %s", fieldPath, selection.name, typeName))
+				continue
+			}
+			if field.IsDeprecated {
+				deprecations = append(deprecations, fmt.Sprintf("%s: %s", fieldPath, field.DeprecationReason))
+			}
+			for _, arg := range selection.args {
+				schemaArg, ok := field.arg(arg.name)
+				if !ok {
+					problems = append(problems, fmt.Sprintf("%s: no argument %s on field %s", fieldPath, arg.name, field.Name))
+					continue
+				}
+				checkValue(fmt.Sprintf("%s(%s)", fieldPath, arg.name), schemaArg.Type, arg.value)
+			}
+			if len(selection.selections) > 0 {
+				walk(field.Type.namedType(), fieldPath, selection.selections)
+			}
+		}
+	}
+	walk(s.QueryType.Name, "", selections)
+
+	if len(problems) > 0 {
+		return deprecations, fmt.Errorf("query does not match schema: %s", strings.Join(problems, "; "))
+	}
+	return deprecations, nil
+}
+
+func (t gqlType) field(name string) (gqlSchemaField, bool) {
+	for _, field := range t.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return gqlSchemaField{}, false
+}
+
+func (t gqlType) inputField(name string) (gqlInputValue, bool) {
+	for _, field := range t.InputFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return gqlInputValue{}, false
+}
+
+func (t gqlType) enumValue(name string) (gqlEnumValue, bool) {
+	for _, value := range t.EnumValues {
+		if value.Name == name {
+			return value, true
+		}
+	}
+	return gqlEnumValue{}, false
+}
+
+func (f gqlSchemaField) arg(name string) (gqlInputValue, bool) {
+	for _, arg := range f.Args {
+		if arg.Name == name {
+			return arg, true
+		}
+	}
+	return gqlInputValue{}, false
+}
+
+// gqlField is a field selected by a query, the arguments passed to it, and the
+// fields selected from it in turn.
+type gqlField struct {
+	name       string
+	args       []gqlArg
+	selections []gqlField
+}
+
+// gqlArg is an argument passed to a field, or a field of an input object.
+type gqlArg struct {
+	name  string
+	value gqlValue
+}
+
+// gqlValue is a variable, a literal such as an enum value, a list or an input
+// object.
+type gqlValue struct {
+	variable string
+	literal  string
+	list     []gqlValue
+	object   []gqlArg
+}
+
+// parseGqlSelections parses the fields selected by a query. It understands only
+// as much GraphQL as our queries use: variables, arguments and aliases, but not
+// fragments or directives.
+func parseGqlSelections(query string) ([]gqlField, error) {
+	p := &gqlParser{tokens: tokenizeGql(query)}
+	if p.peek() == "query" {
+		p.next()
+		if p.peek() != "(" && p.peek() != "{" {
+			p.next() // operation name
+		}
+	}
+	if p.peek() == "(" {
+		if err := p.skipParens(); err != nil {
+			return nil, err
+		}
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, fmt.Errorf("unexpected %q after query", p.peek())
+	}
+	return selections, nil
+}
+
+type gqlParser struct {
+	tokens []string
+}
+
+func (p *gqlParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *gqlParser) next() string {
+	token := p.peek()
+	if len(p.tokens) > 0 {
+		p.tokens = p.tokens[1:]
+	}
+	return token
+}
+
+func (p *gqlParser) expect(token string) error {
+	if next := p.next(); next != token {
+		return fmt.Errorf("expected %q, got %q", token, next)
+	}
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlField, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []gqlField
+	for p.peek() != "}" {
+		name := p.next()
+		if !isGqlName(name) {
+			return nil, fmt.Errorf("expected a field name, got %q", name)
+		}
+		if p.peek() == ":" {
+			p.next()
+			name = p.next()
+			if !isGqlName(name) {
+				return nil, fmt.Errorf("expected a field name after alias, got %q", name)
+			}
+		}
+		field := gqlField{name: name}
+		if p.peek() == "(" {
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			field.args = args
+		}
+		if p.peek() == "{" {
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			field.selections = selections
+		}
+		fields = append(fields, field)
+	}
+	return fields, p.expect("}")
+}
+
+func (p *gqlParser) arguments() ([]gqlArg, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []gqlArg
+	for p.peek() != ")" {
+		arg, err := p.namedValue()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, p.expect(")")
+}
+
+func (p *gqlParser) namedValue() (gqlArg, error) {
+	name := p.next()
+	if !isGqlName(name) {
+		return gqlArg{}, fmt.Errorf("expected an argument name, got %q", name)
+	}
+	if err := p.expect(":"); err != nil {
+		return gqlArg{}, err
+	}
+	value, err := p.value()
+	return gqlArg{name: name, value: value}, err
+}
+
+func (p *gqlParser) value() (gqlValue, error) {
+	switch token := p.next(); {
+	case token == "{":
+		value := gqlValue{object: []gqlArg{}}
+		for p.peek() != "}" {
+			field, err := p.namedValue()
+			if err != nil {
+				return gqlValue{}, err
+			}
+			value.object = append(value.object, field)
+		}
+		return value, p.expect("}")
+	case token == "[":
+		value := gqlValue{list: []gqlValue{}}
+		for p.peek() != "]" {
+			element, err := p.value()
+			if err != nil {
+				return gqlValue{}, err
+			}
+			value.list = append(value.list, element)
+		}
+		return value, p.expect("]")
+	case strings.HasPrefix(token, "$"):
+		return gqlValue{variable: token}, nil
+	case strings.HasPrefix(token, `"`):
+		return gqlValue{literal: token}, nil
+	case token == "" || strings.ContainsAny(token, "}])(:"):
+		return gqlValue{}, fmt.Errorf("expected a value, got %q", token)
+	default:
+		return gqlValue{literal: token}, nil
+	}
+}
+
+// skipParens skips variable definitions.
+func (p *gqlParser) skipParens() error {
+	depth := 0
+	for {
+		switch p.next() {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		case "":
+			return fmt.Errorf("unbalanced parentheses")
+		}
+	}
+}
+
+func isGqlName(token string) bool {
+	if token == "" {
+		return false
+	}
+	for i, r := range token {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenizeGql splits a query into names, punctuation and other values, dropping
+// whitespace, commas and comments.
+func tokenizeGql(query string) []string {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			tokens = append(tokens, string(runes[i:minInt(j+1, len(runes))]))
+			i = j + 1
+		case r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '.':
+			j := i + 1
+			for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// graphqlQueries lists every query the exporter makes, by request kind. Zone
+// datasets are queried for one zone or a batch, each on its own or combined.
+func (e *exporter) graphqlQueries() map[string]string {
+	queries := map[string]string{}
+	// The queries are the same for every account.
+	account := &account{}
+	zoneDatasets := e.zoneDatasets(account)
+	for _, dataset := range zoneDatasets {
+		queries[dataset.requestKind] = zoneGqlQuery(dataset.selection)
+		queries[dataset.requestKind+":batch"] = zoneBatchGqlQuery(dataset.selection)
+	}
+	if len(zoneDatasets) > 1 {
+		queries[combinedRequestKind], _ = combinedZonesGqlQuery(zoneDatasets, false)
+		queries[combinedRequestKind+":batch"], _ = combinedZonesGqlQuery(zoneDatasets, true)
+	}
+	for _, dataset := range e.accountDatasets(account) {
+		queries[dataset.requestKind] = accountGqlQuery(dataset.selection)
+	}
+	return queries
+}
+
+// validateGraphqlSchema introspects the GraphQL schema, using the first
+// account's credentials, and checks every query against it. Deprecated fields
+// are logged as warnings.
+func (e *exporter) validateGraphqlSchema(ctx context.Context) error {
+	account := e.accounts[0]
+	req := newGraphqlRequest(gqlIntrospectionQuery)
+	if err := account.setAuthHeaders(req.Header); err != nil {
+		return err
+	}
+	var resp struct {
+		Schema gqlSchema `json:"__schema"`
+	}
+	err := e.retryPolicy.do(ctx, e.logger, "graphql:introspection", func() error {
+		return observeAPIRequest("graphql:introspection", account.name, "", func() error {
+			return e.graphqlClient.Run(ctx, req, &resp)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("introspecting GraphQL schema: %w", err)
+	}
+	return e.validateGraphqlQueries(&resp.Schema)
+}
+
+func (e *exporter) validateGraphqlQueries(schema *gqlSchema) error {
+	queries := e.graphqlQueries()
+	requestKinds := make([]string, 0, len(queries))
+	for requestKind := range queries {
+		requestKinds = append(requestKinds, requestKind)
+	}
+	sort.Strings(requestKinds)
+
+	var problems []string
+	for _, requestKind := range requestKinds {
+		deprecations, err := schema.validate(queries[requestKind])
+		for _, deprecation := range deprecations {
+			level.Warn(e.logger).Log("msg", "query uses a deprecated field", "request", requestKind, "field", deprecation)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", requestKind, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadGqlSchema(t *testing.T, fixturePath string) *gqlSchema {
+	snapshot, err := os.Open(filepath.Join("testdata", fixturePath))
+	require.Nil(t, err)
+	defer snapshot.Close()
+	schema, err := parseGqlSchema(snapshot)
+	require.Nil(t, err)
+	return schema
+}
+
+func newSchemaTestExporter(graphqlClient graphqlClient) exporter {
+	enabledDatasets := map[string]bool{}
+	for _, dataset := range optionalDatasets {
+		enabledDatasets[dataset] = true
+	}
+	return exporter{
+		accounts:                 []*account{{name: "an-account", credentials: credentials{apiToken: "a-token"}}},
+		graphqlClient:            graphqlClient,
+		logger:                   newPromLogger("error"),
+		enabledDatasets:          enabledDatasets,
+		dnsQueryNames:            map[string]bool{"example.com": true},
+		cacheStatusByContentType: true,
+		colosByUpperTier:         true,
+	}
+}
+
+// TestGraphqlQueries_MatchSchemaSnapshot checks every query the exporter makes
+// against a snapshot of the Cloudflare schema.
+func TestGraphqlQueries_MatchSchemaSnapshot(t *testing.T) {
+	schema := loadGqlSchema(t, "graphql_schema.json")
+	cfExporter := newSchemaTestExporter(nil)
+	queries := cfExporter.graphqlQueries()
+	// Every dataset, zone datasets alone and in batches, and combined.
+	zoneDatasets := 3 + len(optionalDatasets) + len(companionDatasets) - len(cfExporter.accountDatasets(&account{}))
+	require.Len(t, queries, 2*zoneDatasets+len(cfExporter.accountDatasets(&account{}))+2)
+	for requestKind, query := range queries {
+		deprecations, err := schema.validate(query)
+		assert.Nil(t, err, requestKind)
+		assert.Empty(t, deprecations, requestKind)
+	}
+}
+
+// TestUpdateGraphqlSchemaSnapshot replaces the snapshot with the response to
+// the introspection query. It is skipped unless asked for:
+//
+//	UPDATE_GRAPHQL_SCHEMA=1 CLOUDFLARE_API_TOKEN=... go test -run TestUpdateGraphqlSchemaSnapshot
+func TestUpdateGraphqlSchemaSnapshot(t *testing.T) {
+	if os.Getenv("UPDATE_GRAPHQL_SCHEMA") == "" {
+		t.Skip("UPDATE_GRAPHQL_SCHEMA is not set")
+	}
+	apiToken := os.Getenv("CLOUDFLARE_API_TOKEN")
+	require.NotEmpty(t, apiToken, "CLOUDFLARE_API_TOKEN is not set")
+	body, err := json.Marshal(map[string]string{"query": gqlIntrospectionQuery})
+	require.Nil(t, err)
+	req, err := http.NewRequest(http.MethodPost, "https://api.cloudflare.com/client/v4/graphql", bytes.NewReader(body))
+	require.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+apiToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	var snapshot bytes.Buffer
+	require.Nil(t, json.Indent(&snapshot, respBody, "", "  "))
+	_, err = parseGqlSchema(bytes.NewReader(snapshot.Bytes()))
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join("testdata", "graphql_schema.json"), append(snapshot.Bytes(), '\n'), 0644))
+}
+
+func TestGqlSchemaValidate(t *testing.T) {
+	schema := loadGqlSchema(t, "graphql_schema.json")
+	query := `
+query ($zone: String!) {
+  viewer {
+    zones(filter: {zoneTag: $zone}) {
+      tag: zoneTag
+      httpRequests1mGroups(limit: 1, filter: {datetime_gt: "2020-01-01T00:00:00Z"}, orderBy: [datetime_ASC]) {
+        sum {
+          requests
+          bogus
+        }
+        dimensions {
+          datetime
+        }
+      }
+      notADataset {
+        count
+      }
+    }
+  }
+}`
+
+	deprecations, err := schema.validate(query)
+	require.NotNil(t, err)
+	assert.Equal(t, "query does not match schema: "+
+		"viewer.zones.httpRequests1mGroups.sum.bogus: no field bogus on type ZoneHttpRequests1mGroupsSum; "+
+		"viewer.zones.notADataset: no field notADataset on type zone", err.Error())
+	assert.Empty(t, deprecations)
+}
+
+func TestGqlSchemaValidate_Arguments(t *testing.T) {
+	schema := loadGqlSchema(t, "graphql_schema.json")
+	query := `
+query ($zone: String!, $start_time: Time!) {
+  viewer {
+    zones(filter: {zoneTag: $zone, bogus: "a-zone"}) {
+      httpRequests1mGroups(limit: 1, filter: {datetime_gt: $start_time}, orderBy: [datetime_ASC, datetime_UP]) {
+        sum {
+          requests
+        }
+      }
+      firewallEventsAdaptiveGroups(limit: 1, filter: {OR: [{action: "block"}, {actionn: "drop"}]}, sort: [datetime_ASC]) {
+        count
+      }
+      dnsAnalyticsAdaptiveGroups(limit: 1, filter: datetime_ASC) {
+        count
+      }
+    }
+  }
+}`
+
+	deprecations, err := schema.validate(query)
+	require.NotNil(t, err)
+	assert.Equal(t, "query does not match schema: "+
+		"viewer.zones(filter): no input field bogus on type ZoneFilter_InputObject; "+
+		"viewer.zones.httpRequests1mGroups(orderBy): no value datetime_UP in enum ZoneHttpRequests1mGroupsOrderBy; "+
+		"viewer.zones.firewallEventsAdaptiveGroups(filter).OR: no input field actionn on type ZoneFirewallEventsAdaptiveGroupsFilter_InputObject; "+
+		"viewer.zones.firewallEventsAdaptiveGroups: no argument sort on field firewallEventsAdaptiveGroups; "+
+		"viewer.zones.dnsAnalyticsAdaptiveGroups(filter): expected an input object of type ZoneDnsAnalyticsAdaptiveGroupsFilter_InputObject",
+		err.Error())
+	assert.Empty(t, deprecations)
+}
+
+// TestGqlSchemaValidate_Deprecations uses a small schema of its own, as the
+// snapshot may not have any deprecations.
+func TestGqlSchemaValidate_Deprecations(t *testing.T) {
+	schema := loadGqlSchema(t, "graphql_schema_deprecations.json")
+	query := `
+query ($zone: String!, $start_time: Time!) {
+  viewer {
+    zones(filter: {zoneTag: $zone}) {
+      httpRequests1mGroups(limit: 1, filter: {datetime_gt: $start_time}, orderBy: [date_ASC]) {
+        dimensions {
+          date
+          datetime
+        }
+      }
+    }
+  }
+}`
+
+	deprecations, err := schema.validate(query)
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"viewer.zones.httpRequests1mGroups(orderBy): date_ASC: Use datetime instead.",
+		"viewer.zones.httpRequests1mGroups.dimensions.date: Use datetime instead.",
+	}, deprecations)
+}
+
+func TestParseGqlSelections_Invalid(t *testing.T) {
+	for _, query := range []string{
+		`query { viewer { zones }`,
+		`query ($zone: String! { viewer }`,
+		`{ viewer } }`,
+	} {
+		_, err := parseGqlSelections(query)
+		assert.NotNil(t, err, query)
+	}
+}
+
+func TestValidateGraphqlSchema(t *testing.T) {
+	registerMetrics(prometheus.NewPedanticRegistry())
+	graphqlClient := newFakeGraphqlClient([]string{"graphql_schema.json"})
+	cfExporter := newSchemaTestExporter(graphqlClient)
+
+	require.Nil(t, cfExporter.validateGraphqlSchema(context.Background()))
+	require.Len(t, graphqlClient.requests, 1)
+	assert.Equal(t, gqlIntrospectionQuery, graphqlClient.requests[0].query)
+}
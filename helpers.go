@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 func timeOperation(f func() error) (time.Duration, error) {
 	start := time.Now()
@@ -8,6 +11,19 @@ func timeOperation(f func() error) (time.Duration, error) {
 	return time.Since(start), err
 }
 
+// observeAPIRequest records the latency and outcome of a single attempt at a
+// Cloudflare API request.
+func observeAPIRequest(requestKind, account, zone string, f func() error) error {
+	duration, err := timeOperation(f)
+	outcome := "success"
+	if err != nil {
+		outcome = string(errorKindOf(err))
+	}
+	apiRequests.WithLabelValues(requestKind, account, zone, outcome).Inc()
+	apiRequestDuration.WithLabelValues(requestKind, account, zone, outcome).Observe(duration.Seconds())
+	return err
+}
+
 func contains(list []string, str string) bool {
 	for _, e := range list {
 		if e == str {
@@ -16,3 +32,12 @@ func contains(list []string, str string) bool {
 	}
 	return false
 }
+
+// splitList splits a comma-separated flag value, returning nil for an empty
+// string.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
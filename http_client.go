@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// httpClientConfig configures the HTTP client shared by requests to the
+// Cloudflare REST and GraphQL APIs.
+type httpClientConfig struct {
+	// Defaults to the HTTP(S)_PROXY environment variables if empty.
+	proxyURL string
+	// PEM bundle of CAs to trust instead of the system roots.
+	caFile string
+	// PEM client certificate and key, for egress proxies that require mTLS.
+	certFile string
+	keyFile  string
+
+	connectTimeout        time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConns          int
+}
+
+func newHTTPClient(config httpClientConfig) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.proxyURL != "" {
+		proxyURL, err := url.Parse(config.proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy URL: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.caFile != "" {
+		caPEM, err := ioutil.ReadFile(config.caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", config.caFile)
+		}
+	}
+	if config.certFile != "" || config.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.certFile, config.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   config.connectTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   config.tlsHandshakeTimeout,
+			ResponseHeaderTimeout: config.responseHeaderTimeout,
+			IdleConnTimeout:       config.idleConnTimeout,
+			MaxIdleConns:          config.maxIdleConns,
+			MaxIdleConnsPerHost:   config.maxIdleConns,
+			ForceAttemptHTTP2:     true,
+		},
+	}, nil
+}
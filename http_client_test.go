@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClient_TrustsCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "cloudflare_exporter")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.Nil(t, ioutil.WriteFile(caPath, caPEM, 0600))
+
+	client, err := newHTTPClient(httpClientConfig{})
+	require.Nil(t, err)
+	_, err = client.Get(server.URL)
+	assert.NotNil(t, err, "server certificate should not be trusted by default")
+
+	client, err = newHTTPClient(httpClientConfig{caFile: caPath})
+	require.Nil(t, err)
+	resp, err := client.Get(server.URL)
+	require.Nil(t, err)
+	resp.Body.Close()
+}
+
+func TestNewHTTPClient_UsesProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "cloudflare.invalid", r.URL.Host)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(httpClientConfig{proxyURL: proxy.URL})
+	require.Nil(t, err)
+	resp, err := client.Get("http://cloudflare.invalid/zones")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+}
+
+func TestNewHTTPClient_InvalidConfig(t *testing.T) {
+	for name, config := range map[string]httpClientConfig{
+		"missing CA file":           {caFile: "testdata/does-not-exist"},
+		"CA file without any certs": {caFile: "testdata/zones_resp.json"},
+		"client cert without key":   {certFile: "testdata/zones_resp.json"},
+		"unparseable proxy URL":     {proxyURL: "http://[::1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newHTTPClient(config)
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Load balancer pools and their origins belong to Cloudflare accounts. Their
+// health is checked from several regions, and reported by the REST API as it
+// stands now, unlike health check events, which are only recorded when it
+// changes. Load balancers belong to zones, and steer traffic to pools.
+
+var (
+	lbPoolInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "lb", "pool_info"),
+		"Load balancer pool configuration. Always 1.",
+		[]string{"account", "cloudflare_account", "pool", "pool_id", "enabled", "monitor"}, nil,
+	)
+	lbPoolHealthyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "lb", "pool_healthy"),
+		"Whether a load balancer pool is healthy from every region that checks it.",
+		[]string{"account", "cloudflare_account", "pool"}, nil,
+	)
+	lbOriginInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "lb", "origin_info"),
+		"Load balancer origin configuration. Always 1.",
+		[]string{"account", "cloudflare_account", "pool", "origin", "address", "enabled"}, nil,
+	)
+	lbOriginWeightDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "lb", "origin_weight"),
+		"Share of its pool's traffic that a load balancer origin is weighted to receive.",
+		[]string{"account", "cloudflare_account", "pool", "origin"}, nil,
+	)
+	lbOriginHealthyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "lb", "origin_healthy"),
+		"Whether a load balancer origin is healthy from every region that checks it.",
+		[]string{"account", "cloudflare_account", "pool", "origin"}, nil,
+	)
+	lbLoadBalancerInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "lb", "load_balancer_info"),
+		"Load balancer configuration. Always 1.",
+		[]string{"account", "zone", "load_balancer", "enabled", "steering_policy"}, nil,
+	)
+	lbLoadBalancerPoolDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "lb", "load_balancer_pool"),
+		"Pool that a load balancer steers traffic to, by role: default or fallback. Always 1.",
+		[]string{"account", "zone", "load_balancer", "pool_id", "role"}, nil,
+	)
+)
+
+// lbHealthCollector exposes the load balancer pools and origins of each
+// Cloudflare account, and the load balancers of each zone, as last refreshed.
+// Each account's metrics are replaced at once, so that scrapes never see a
+// refresh in progress.
+type lbHealthCollector struct {
+	lock sync.Mutex
+	// metrics by account name, then Cloudflare account or zone ID.
+	metrics map[string]map[string][]prometheus.Metric
+}
+
+func newLBHealthCollector() *lbHealthCollector {
+	return &lbHealthCollector{metrics: map[string]map[string][]prometheus.Metric{}}
+}
+
+func (c *lbHealthCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- lbPoolInfoDesc
+	descs <- lbPoolHealthyDesc
+	descs <- lbOriginInfoDesc
+	descs <- lbOriginWeightDesc
+	descs <- lbOriginHealthyDesc
+	descs <- lbLoadBalancerInfoDesc
+	descs <- lbLoadBalancerPoolDesc
+}
+
+func (c *lbHealthCollector) Collect(metrics chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, accountMetrics := range c.metrics {
+		for _, cloudflareAccountMetrics := range accountMetrics {
+			for _, metric := range cloudflareAccountMetrics {
+				metrics <- metric
+			}
+		}
+	}
+}
+
+func (c *lbHealthCollector) get(account string) map[string][]prometheus.Metric {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.metrics[account]
+}
+
+func (c *lbHealthCollector) set(account string, metrics map[string][]prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metrics[account] = metrics
+}
+
+type lbPool struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Monitor string `json:"monitor"`
+	Origins []struct {
+		Name    string  `json:"name"`
+		Address string  `json:"address"`
+		Enabled bool    `json:"enabled"`
+		Weight  float64 `json:"weight"`
+	} `json:"origins"`
+}
+
+type loadBalancer struct {
+	Name           string   `json:"name"`
+	Enabled        bool     `json:"enabled"`
+	SteeringPolicy string   `json:"steering_policy"`
+	DefaultPools   []string `json:"default_pools"`
+	FallbackPool   string   `json:"fallback_pool"`
+}
+
+// lbPoolHealth is the health of a pool, and of its origins by address, from
+// each region that checks it.
+type lbPoolHealth struct {
+	PopHealth map[string]struct {
+		Healthy bool `json:"healthy"`
+		Origins []map[string]struct {
+			Healthy bool `json:"healthy"`
+		} `json:"origins"`
+	} `json:"pop_health"`
+}
+
+// healthy reports whether the pool, and each origin by address, is healthy
+// from every region. Pools and origins that no region checks are left out.
+func (h lbPoolHealth) healthy() (map[string]bool, bool, bool) {
+	poolHealthy, poolChecked := true, false
+	originsHealthy := map[string]bool{}
+	for _, pop := range h.PopHealth {
+		poolChecked = true
+		poolHealthy = poolHealthy && pop.Healthy
+		for _, origins := range pop.Origins {
+			for address, origin := range origins {
+				healthy, ok := originsHealthy[address]
+				originsHealthy[address] = (healthy || !ok) && origin.Healthy
+			}
+		}
+	}
+	return originsHealthy, poolHealthy, poolChecked
+}
+
+// refreshLoadBalancerHealth lists every account's load balancer pools, and
+// their health, and load balancers, at startup and then periodically.
+func (e *exporter) refreshLoadBalancerHealth(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, account := range e.accounts {
+			if err := e.refreshAccountLoadBalancerHealth(ctx, account); err != nil && ctx.Err() == nil {
+				// The health last refreshed is still exposed, and the age of it
+				// by cloudflare_lb_health_last_success_timestamp_seconds.
+				level.Error(e.logger).Log("msg", "refreshing load balancer health failed", "account", account.name, "error", err)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// refreshAccountLoadBalancerHealth refreshes the load balancer pools of each
+// Cloudflare account, and the load balancers of each zone, that an account can
+// access, keeping the previous metrics of those that fail. Listing the zones
+// and Cloudflare accounts, if they have not been yet, and each Cloudflare
+// account and zone refreshed, are each allowed the scrape timeout, so that
+// accounts with many zones do not run out of time.
+func (e *exporter) refreshAccountLoadBalancerHealth(ctx context.Context, account *account) error {
+	e.scrapeLock.Lock()
+	cloudflareAccounts, zones := account.cloudflareAccounts, account.zones
+	e.scrapeLock.Unlock()
+
+	// Requests are timed from here, rather than including any wait for the
+	// lock.
+	listCtx, cancel := context.WithTimeout(ctx, e.scrapeTimeout)
+	defer cancel()
+	if zones == nil {
+		var err error
+		if zones, err = e.getZones(listCtx, account); err != nil {
+			return err
+		}
+		e.scrapeLock.Lock()
+		e.updateZones(account, zones)
+		e.scrapeLock.Unlock()
+	}
+	if cloudflareAccounts == nil {
+		var err error
+		if cloudflareAccounts, err = e.getCloudflareAccounts(listCtx, account); err != nil {
+			return err
+		}
+		e.scrapeLock.Lock()
+		e.updateCloudflareAccounts(account, cloudflareAccounts)
+		e.scrapeLock.Unlock()
+	}
+
+	previous := lbHealth.get(account.name)
+	metrics := map[string][]prometheus.Metric{}
+	var errs scrapeErrors
+	for cloudflareAccountID, cloudflareAccountName := range cloudflareAccounts {
+		cloudflareAccountCtx, cancel := context.WithTimeout(ctx, e.scrapeTimeout)
+		cloudflareAccountMetrics, err := e.loadBalancerHealthMetrics(
+			cloudflareAccountCtx, account, cloudflareAccountID, cloudflareAccountName,
+		)
+		cancel()
+		if err != nil {
+			errs.add(fmt.Errorf("cloudflare account %s: %w", cloudflareAccountName, err))
+			cloudflareAccountMetrics = previous[cloudflareAccountID]
+		}
+		metrics[cloudflareAccountID] = cloudflareAccountMetrics
+	}
+	for zoneID, zoneName := range zones {
+		zoneCtx, cancel := context.WithTimeout(ctx, e.scrapeTimeout)
+		zoneMetrics, err := e.loadBalancerMetrics(zoneCtx, account, zoneID, zoneName)
+		cancel()
+		if err != nil {
+			errs.add(fmt.Errorf("zone %s: %w", zoneName, err))
+			zoneMetrics = previous[zoneID]
+		}
+		metrics[zoneID] = zoneMetrics
+	}
+	lbHealth.set(account.name, metrics)
+	if err := errs.err(); err != nil {
+		return err
+	}
+	lbHealthLastSuccessTimestampSeconds.WithLabelValues(account.name).SetToCurrentTime()
+	return nil
+}
+
+func (e *exporter) loadBalancerHealthMetrics(
+	ctx context.Context, account *account, cloudflareAccountID, cloudflareAccountName string,
+) ([]prometheus.Metric, error) {
+	var pools []lbPool
+	poolsPath := fmt.Sprintf("/accounts/%s/load_balancers/pools", cloudflareAccountID)
+	if _, err := e.restClient.get(ctx, account, "rest:lb_pools", poolsPath, nil, &pools); err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, pool := range pools {
+		var health lbPoolHealth
+		healthPath := fmt.Sprintf("%s/%s/health", poolsPath, pool.ID)
+		if _, err := e.restClient.get(ctx, account, "rest:lb_pool_health", healthPath, nil, &health); err != nil {
+			return nil, fmt.Errorf("pool %s: %w", pool.Name, err)
+		}
+		originsHealthy, poolHealthy, poolChecked := health.healthy()
+
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			lbPoolInfoDesc, prometheus.GaugeValue, 1,
+			account.name, cloudflareAccountName, pool.Name, pool.ID, strconv.FormatBool(pool.Enabled), pool.Monitor,
+		))
+		if poolChecked {
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				lbPoolHealthyDesc, prometheus.GaugeValue, boolToFloat(poolHealthy),
+				account.name, cloudflareAccountName, pool.Name,
+			))
+		}
+		for _, origin := range pool.Origins {
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(
+					lbOriginInfoDesc, prometheus.GaugeValue, 1,
+					account.name, cloudflareAccountName, pool.Name, origin.Name, origin.Address,
+					strconv.FormatBool(origin.Enabled),
+				),
+				prometheus.MustNewConstMetric(
+					lbOriginWeightDesc, prometheus.GaugeValue, origin.Weight,
+					account.name, cloudflareAccountName, pool.Name, origin.Name,
+				),
+			)
+			if healthy, ok := originsHealthy[origin.Address]; ok {
+				metrics = append(metrics, prometheus.MustNewConstMetric(
+					lbOriginHealthyDesc, prometheus.GaugeValue, boolToFloat(healthy),
+					account.name, cloudflareAccountName, pool.Name, origin.Name,
+				))
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// loadBalancerMetrics describes the load balancers of a zone, and the pools
+// they steer traffic to.
+func (e *exporter) loadBalancerMetrics(ctx context.Context, account *account, zoneID, zoneName string) ([]prometheus.Metric, error) {
+	var loadBalancers []loadBalancer
+	path := fmt.Sprintf("/zones/%s/load_balancers", zoneID)
+	if _, err := e.restClient.get(ctx, account, "rest:load_balancers", path, nil, &loadBalancers); err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, lb := range loadBalancers {
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			lbLoadBalancerInfoDesc, prometheus.GaugeValue, 1,
+			account.name, zoneName, lb.Name, strconv.FormatBool(lb.Enabled), lb.SteeringPolicy,
+		))
+		for _, poolID := range lb.DefaultPools {
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				lbLoadBalancerPoolDesc, prometheus.GaugeValue, 1,
+				account.name, zoneName, lb.Name, poolID, "default",
+			))
+		}
+		if lb.FallbackPool != "" {
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				lbLoadBalancerPoolDesc, prometheus.GaugeValue, 1,
+				account.name, zoneName, lb.Name, lb.FallbackPool, "fallback",
+			))
+		}
+	}
+	return metrics, nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
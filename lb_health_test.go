@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshLoadBalancerHealth(t *testing.T) {
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"success": false, "errors": [{"code": 1000, "message": "internal error"}]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/accounts/an-account-id/load_balancers/pools":
+			_, _ = w.Write([]byte(`{"success": true, "result": [
+				{
+					"id": "a-pool-id", "name": "primary", "enabled": true, "monitor": "a-monitor-id",
+					"origins": [
+						{"name": "origin-1", "address": "192.0.2.1", "enabled": true, "weight": 0.5},
+						{"name": "origin-2", "address": "192.0.2.2", "enabled": true, "weight": 0.5},
+						{"name": "origin-3", "address": "192.0.2.3", "enabled": false, "weight": 1}
+					]
+				},
+				{"id": "another-pool-id", "name": "fallback", "enabled": false, "origins": []}
+			]}`))
+		case "/accounts/an-account-id/load_balancers/pools/a-pool-id/health":
+			_, _ = w.Write([]byte(`{"success": true, "result": {"pool_id": "a-pool-id", "pop_health": {
+				"Amsterdam, NL": {"healthy": true, "origins": [
+					{"192.0.2.1": {"healthy": true, "rtt": "12ms"}},
+					{"192.0.2.2": {"healthy": true, "rtt": "14ms"}}
+				]},
+				"Singapore, SG": {"healthy": false, "origins": [
+					{"192.0.2.1": {"healthy": true, "rtt": "120ms"}},
+					{"192.0.2.2": {"healthy": false, "failure_reason": "HTTP timeout occurred"}}
+				]}
+			}}}`))
+		case "/accounts/an-account-id/load_balancers/pools/another-pool-id/health":
+			_, _ = w.Write([]byte(`{"success": true, "result": {"pool_id": "another-pool-id", "pop_health": {}}}`))
+		case "/zones/a-zone-id/load_balancers":
+			_, _ = w.Write([]byte(`{"success": true, "result": [
+				{
+					"id": "a-load-balancer-id", "name": "www.example.com", "enabled": true, "steering_policy": "geo",
+					"default_pools": ["a-pool-id"], "fallback_pool": "another-pool-id"
+				}
+			]}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	reg := prometheus.NewPedanticRegistry()
+	registerMetrics(reg)
+
+	cfExporter := exporter{
+		logger:        newPromLogger("error"),
+		restClient:    newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error")),
+		scrapeTimeout: time.Minute,
+		scrapeLock:    &sync.Mutex{},
+	}
+	account := &account{
+		name:               "an-account",
+		zones:              map[string]string{"a-zone-id": "example.com"},
+		cloudflareAccounts: map[string]string{"an-account-id": "An Account"},
+	}
+	require.Nil(t, cfExporter.refreshAccountLoadBalancerHealth(context.Background(), account))
+	assert.InDelta(t, float64(time.Now().Unix()), testutil.ToFloat64(
+		lbHealthLastSuccessTimestampSeconds.WithLabelValues("an-account"),
+	), 10)
+
+	// A pool or origin is only healthy if every region that checks it says so,
+	// and there is no health for those that no region checks. Load balancers
+	// are linked to their pools by pool ID.
+	compareLBHealthMetrics(t, reg)
+
+	// The health last refreshed is still exposed when a refresh fails.
+	failing = true
+	lbHealthLastSuccessTimestampSeconds.WithLabelValues("an-account").Set(0)
+	require.NotNil(t, cfExporter.refreshAccountLoadBalancerHealth(context.Background(), account))
+	assert.Equal(t, 0.0, testutil.ToFloat64(lbHealthLastSuccessTimestampSeconds.WithLabelValues("an-account")))
+	compareLBHealthMetrics(t, reg)
+}
+
+func TestRefreshLoadBalancerHealth_TimesEachZone(t *testing.T) {
+	// Each zone's load balancers take most of the timeout to list.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"success": true, "result": []}`))
+	}))
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	cfExporter := exporter{
+		logger:        newPromLogger("error"),
+		restClient:    newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error")),
+		scrapeTimeout: 250 * time.Millisecond,
+		scrapeLock:    &sync.Mutex{},
+	}
+	account := &account{
+		name: "an-account",
+		zones: map[string]string{
+			"a-zone-id": "a.example.com", "b-zone-id": "b.example.com", "c-zone-id": "c.example.com",
+		},
+		cloudflareAccounts: map[string]string{},
+	}
+	require.Nil(t, cfExporter.refreshAccountLoadBalancerHealth(context.Background(), account))
+}
+
+// startedGraphqlClient signals its first query, and otherwise answers as
+// fakeGraphqlClient.
+type startedGraphqlClient struct {
+	*fakeGraphqlClient
+	once    sync.Once
+	started chan struct{}
+}
+
+func (g *startedGraphqlClient) Run(ctx context.Context, req *graphqlRequest, respPtr interface{}) error {
+	g.once.Do(func() { close(g.started) })
+	return g.fakeGraphqlClient.Run(ctx, req, respPtr)
+}
+
+func TestRefreshLoadBalancerHealth_DuringPacedScrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success": true, "result": []}`))
+	}))
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	// The scrape's three queries are paced over two seconds, far longer than
+	// the refresh may take.
+	anAccount := &account{
+		name:               "an-account",
+		queryBudget:        newQueryBudget(3000, 2*time.Second),
+		zones:              map[string]string{"a-zone": "a-zone-name"},
+		cloudflareAccounts: map[string]string{"an-account-id": "An Account"},
+	}
+	graphqlClient := &startedGraphqlClient{
+		fakeGraphqlClient: newFakeGraphqlClient([]string{"http_reqs_resp.json"}),
+		started:           make(chan struct{}),
+	}
+	cfExporter := exporter{
+		accounts:       []*account{anAccount},
+		logger:         newPromLogger("error"),
+		graphqlClient:  graphqlClient,
+		restClient:     newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error")),
+		scrapeInterval: 2 * time.Second,
+		scrapeTimeout:  500 * time.Millisecond,
+		scrapeLock:     &sync.Mutex{},
+	}
+	scraped := make(chan error)
+	go func() { scraped <- cfExporter.scrapeCloudflareOnce(context.Background()) }()
+	<-graphqlClient.started
+
+	require.Nil(t, cfExporter.refreshAccountLoadBalancerHealth(context.Background(), anAccount))
+	select {
+	case <-scraped:
+		t.Fatal("the refresh waited for the scrape to finish")
+	default:
+	}
+	require.Nil(t, <-scraped)
+}
+
+func compareLBHealthMetrics(t *testing.T, reg prometheus.Gatherer) {
+	fixture, err := os.Open(filepath.Join("testdata", "expected_lb_health.metrics"))
+	require.Nil(t, err)
+	defer fixture.Close()
+	err = testutil.GatherAndCompare(reg, fixture,
+		"cloudflare_lb_pool_info", "cloudflare_lb_pool_healthy",
+		"cloudflare_lb_origin_info", "cloudflare_lb_origin_weight", "cloudflare_lb_origin_healthy",
+		"cloudflare_lb_load_balancer_info", "cloudflare_lb_load_balancer_pool",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,31 +5,74 @@ import (
 )
 
 var (
-	zonesActive                   prometheus.Gauge
-	httpCountryRequests           *TimestampedMetricVec
-	httpCountryThreats            *TimestampedMetricVec
-	httpCountryBytes              *TimestampedMetricVec
-	httpProtocolRequests          *TimestampedMetricVec
-	httpResponses                 *TimestampedMetricVec
-	httpThreats                   *TimestampedMetricVec
-	httpCachedRequests            *TimestampedMetricVec
-	httpCachedBytes               *TimestampedMetricVec
-	firewallEvents                *TimestampedMetricVec
-	healthCheckEvents             *TimestampedMetricVec
-	cfScrapes                     prometheus.Counter
-	cfScrapeErrs                  prometheus.Counter
-	cfLastSuccessTimestampSeconds prometheus.Gauge
+	zonesActive                           *prometheus.GaugeVec
+	zonesListed                           *prometheus.GaugeVec
+	httpCountryRequests                   *TimestampedMetricVec
+	httpCountryThreats                    *TimestampedMetricVec
+	httpCountryBytes                      *TimestampedMetricVec
+	httpProtocolRequests                  *TimestampedMetricVec
+	httpResponses                         *TimestampedMetricVec
+	httpThreats                           *TimestampedMetricVec
+	httpCachedRequests                    *TimestampedMetricVec
+	httpCachedBytes                       *TimestampedMetricVec
+	firewallEvents                        *TimestampedMetricVec
+	healthCheckEvents                     *TimestampedMetricVec
+	loadBalancingRequests                 *TimestampedMetricVec
+	dnsQueries                            *TimestampedMetricVec
+	dnsQueryNameQueries                   *TimestampedMetricVec
+	httpCacheStatusRequests               *TimestampedMetricVec
+	httpCacheStatusBytes                  *TimestampedMetricVec
+	httpContentTypeCacheStatusRequests    *TimestampedMetricVec
+	httpEdgeTimeToFirstByte               *TimestampedMetricVec
+	httpOriginResponseDuration            *TimestampedMetricVec
+	httpEdgeDNSResponseTime               *TimestampedMetricVec
+	httpColoRequests                      *TimestampedMetricVec
+	httpColoBytes                         *TimestampedMetricVec
+	httpColo5xxResponses                  *TimestampedMetricVec
+	httpColoUpperTierRequests             *TimestampedMetricVec
+	cfScrapes                             prometheus.Counter
+	cfScrapeErrs                          *prometheus.CounterVec
+	cfLastSuccessTimestampSeconds         prometheus.Gauge
+	graphqlQueriesDeferred                *prometheus.CounterVec
+	apiRequests                           *prometheus.CounterVec
+	apiRequestDuration                    *prometheus.HistogramVec
+	apiRequestRetries                     *prometheus.CounterVec
+	credentialsLastReloadTimestampSeconds *prometheus.GaugeVec
+	datasetAvailable                      *prometheus.GaugeVec
+	zoneChanges                           *prometheus.CounterVec
+	zoneScrapeErrs                        *prometheus.CounterVec
+	zoneLastSuccessTimestampSeconds       *prometheus.GaugeVec
+	workersRequests                       *TimestampedMetricVec
+	workersErrors                         *TimestampedMetricVec
+	workersSubrequests                    *TimestampedMetricVec
+	workersCPUTime                        *TimestampedMetricVec
+	workersWallTime                       *TimestampedMetricVec
+	cloudflareAccountsActive              *prometheus.GaugeVec
+	accountScrapeErrs                     *prometheus.CounterVec
+	accountLastSuccessTimestampSeconds    *prometheus.GaugeVec
+	lbHealth                              *lbHealthCollector
+	lbHealthLastSuccessTimestampSeconds   *prometheus.GaugeVec
 )
 
 func registerMetrics(reg prometheus.Registerer) {
 	// zone metrics
-	zonesActive = prometheus.NewGauge(
+	zonesActive = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
 			Subsystem: "zones",
 			Name:      "active",
-			Help:      "Number of active zones in the target Cloudflare account",
+			Help:      "Number of zones scraped in the target Cloudflare account, after zone filters are applied.",
 		},
+		[]string{"account"},
+	)
+	zonesListed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "listed",
+			Help:      "Number of zones the target Cloudflare account can access, as counted by the API before zone filters are applied.",
+		},
+		[]string{"account"},
 	)
 	httpCountryRequests = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -39,7 +82,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "http_country_requests_total",
 			Help:      "Number of HTTP requests by country.",
 		},
-		[]string{"zone", "client_country_name"},
+		[]string{"account", "zone", "client_country_name"},
 	)
 	httpCountryThreats = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -49,7 +92,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "http_country_threats_total",
 			Help:      "Number of HTTP threats by country.",
 		},
-		[]string{"zone", "client_country_name"},
+		[]string{"account", "zone", "client_country_name"},
 	)
 	httpCountryBytes = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -59,7 +102,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "http_country_bytes_total",
 			Help:      "Number of HTTP bytes by country.",
 		},
-		[]string{"zone", "client_country_name"},
+		[]string{"account", "zone", "client_country_name"},
 	)
 	httpProtocolRequests = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -69,7 +112,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "http_protocol_requests_total",
 			Help:      "Number of HTTP requests by protocol.",
 		},
-		[]string{"zone", "client_http_protocol"},
+		[]string{"account", "zone", "client_http_protocol"},
 	)
 	httpResponses = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -79,7 +122,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "http_responses_total",
 			Help:      "Number of HTTP responses by status.",
 		},
-		[]string{"zone", "edge_response_status"},
+		[]string{"account", "zone", "edge_response_status"},
 	)
 	httpThreats = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -89,7 +132,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "http_threats_total",
 			Help:      "Number of HTTP threats by threat path.",
 		},
-		[]string{"zone", "threat_pathing_name"},
+		[]string{"account", "zone", "threat_pathing_name"},
 	)
 	httpCachedRequests = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -99,7 +142,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "http_cached_requests_total",
 			Help:      "Number of cached HTTP requests served.",
 		},
-		[]string{"zone"},
+		[]string{"account", "zone"},
 	)
 	httpCachedBytes = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -109,7 +152,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "http_cached_bytes_total",
 			Help:      "Number of cached HTTP bytes served.",
 		},
-		[]string{"zone"},
+		[]string{"account", "zone"},
 	)
 	firewallEvents = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -119,7 +162,7 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "firewall_events_total",
 			Help:      "Number of firewall events.",
 		},
-		[]string{"zone", "action", "source", "ruleID", "edgeResponseStatus", "originResponseStatus"},
+		[]string{"account", "zone", "action", "source", "ruleID", "edgeResponseStatus", "originResponseStatus"},
 	)
 	healthCheckEvents = NewTimestampedMetricVec(
 		prometheus.CounterValue,
@@ -129,7 +172,137 @@ func registerMetrics(reg prometheus.Registerer) {
 			Name:      "health_check_events_total",
 			Help:      "Number of health check events.",
 		},
-		[]string{"zone", "failure_reason", "health_check_name", "health_status", "origin_response_status", "region", "scope"},
+		[]string{"account", "zone", "failure_reason", "health_check_name", "health_status", "origin_response_status", "region", "scope"},
+	)
+	loadBalancingRequests = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "load_balancing_requests_total",
+			Help:      "Number of requests steered by load balancers, by selected pool and origin.",
+		},
+		[]string{"account", "zone", "lb_name", "pool", "origin", "steering_policy", "fallback"},
+	)
+	dnsQueries = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "dns_queries_total",
+			Help:      "Number of authoritative DNS queries by query type and response code.",
+		},
+		[]string{"account", "zone", "query_type", "response_code"},
+	)
+	dnsQueryNameQueries = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "dns_query_name_queries_total",
+			Help:      "Number of authoritative DNS queries of the names counted separately by query name and response code.",
+		},
+		[]string{"account", "zone", "query_name", "response_code"},
+	)
+	httpCacheStatusRequests = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_cache_status_requests_total",
+			Help:      "Number of HTTP requests by cache status.",
+		},
+		[]string{"account", "zone", "cache_status"},
+	)
+	httpCacheStatusBytes = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_cache_status_bytes_total",
+			Help:      "Number of HTTP response bytes by cache status.",
+		},
+		[]string{"account", "zone", "cache_status"},
+	)
+	httpContentTypeCacheStatusRequests = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_content_type_cache_status_requests_total",
+			Help:      "Number of HTTP requests by response content type and cache status.",
+		},
+		[]string{"account", "zone", "content_type", "cache_status"},
+	)
+	httpEdgeTimeToFirstByte = NewTimestampedMetricVec(
+		prometheus.GaugeValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_edge_time_to_first_byte_seconds",
+			Help:      "Quantiles of the time from Cloudflare receiving HTTP requests to sending the first byte of the response, in the latest minute.",
+		},
+		[]string{"account", "zone", "quantile"},
+	)
+	httpOriginResponseDuration = NewTimestampedMetricVec(
+		prometheus.GaugeValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_origin_response_duration_seconds",
+			Help:      "Quantiles of the time taken by the origin to respond to HTTP requests, in the latest minute.",
+		},
+		[]string{"account", "zone", "quantile"},
+	)
+	httpEdgeDNSResponseTime = NewTimestampedMetricVec(
+		prometheus.GaugeValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_edge_dns_response_time_seconds",
+			Help:      "Quantiles of the time taken to resolve the origin of HTTP requests, in the latest minute.",
+		},
+		[]string{"account", "zone", "quantile"},
+	)
+	httpColoRequests = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_colo_requests_total",
+			Help:      "Number of HTTP requests by the Cloudflare colo (data centre) that served them.",
+		},
+		[]string{"account", "zone", "colo"},
+	)
+	httpColoBytes = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_colo_bytes_total",
+			Help:      "Number of HTTP response bytes by the Cloudflare colo (data centre) that served them.",
+		},
+		[]string{"account", "zone", "colo"},
+	)
+	httpColo5xxResponses = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_colo_5xx_responses_total",
+			Help:      "Number of HTTP responses with a 5xx status by the Cloudflare colo (data centre) that served them.",
+		},
+		[]string{"account", "zone", "colo"},
+	)
+	httpColoUpperTierRequests = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "http_colo_upper_tier_requests_total",
+			Help:      "Number of HTTP requests by the Cloudflare colo that served them and the upper tier colo they were fetched through.",
+		},
+		[]string{"account", "zone", "colo", "upper_tier_colo"},
 	)
 
 	// graphql metrics
@@ -141,14 +314,20 @@ func registerMetrics(reg prometheus.Registerer) {
 			Help:      "Number of times this exporter has scraped cloudflare",
 		},
 	)
-	cfScrapeErrs = prometheus.NewCounter(
+	cfScrapeErrs = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: "graphql",
 			Name:      "scrape_errors_total",
-			Help:      "Number of times this exporter has failed to scrape cloudflare",
+			Help:      "Number of times this exporter has failed to scrape cloudflare, by kind of error",
 		},
+		[]string{"kind"},
 	)
+	// Initialise every kind, so that rates can be computed from the first error
+	// of each kind onwards.
+	for _, kind := range errorKinds {
+		cfScrapeErrs.WithLabelValues(string(kind))
+	}
 	cfLastSuccessTimestampSeconds = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
@@ -158,10 +337,188 @@ func registerMetrics(reg prometheus.Registerer) {
 		},
 	)
 
+	graphqlQueriesDeferred = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "graphql",
+			Name:      "queries_deferred_total",
+			Help:      "Number of zone dataset queries deferred to a later scrape because the query budget was exhausted",
+		},
+		[]string{"account"},
+	)
+
+	// api metrics
+	apiRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "requests_total",
+			Help:      "Number of requests (including retries) made to the Cloudflare APIs, by request kind, zone and outcome",
+		},
+		[]string{"request", "account", "zone", "outcome"},
+	)
+	apiRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of requests to the Cloudflare APIs, by request kind, zone and outcome",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+		},
+		[]string{"request", "account", "zone", "outcome"},
+	)
+	apiRequestRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "request_retries_total",
+			Help:      "Number of times a Cloudflare API request has been retried, by request kind",
+		},
+		[]string{"request"},
+	)
+
+	// exporter metrics
+	credentialsLastReloadTimestampSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "credentials_last_reload_success_timestamp_seconds",
+			Help:      "Time that an account's credential file was last successfully (re)loaded.",
+		},
+		[]string{"account"},
+	)
+	datasetAvailable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "dataset_available",
+			Help:      "Whether an account's analytics dataset could be queried at startup (1) or not (0), for the zone it was checked with.",
+		},
+		[]string{"account", "zone", "dataset"},
+	)
+
+	// Cloudflare account metrics
+	workersRequests = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "workers",
+			Name:      "requests_total",
+			Help:      "Number of Workers invocations, by script and outcome.",
+		},
+		[]string{"account", "cloudflare_account", "script_name", "status"},
+	)
+	workersErrors = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "workers",
+			Name:      "errors_total",
+			Help:      "Number of Workers invocations that failed, by script and outcome.",
+		},
+		[]string{"account", "cloudflare_account", "script_name", "status"},
+	)
+	workersSubrequests = NewTimestampedMetricVec(
+		prometheus.CounterValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "workers",
+			Name:      "subrequests_total",
+			Help:      "Number of subrequests made by Workers invocations, by script and outcome.",
+		},
+		[]string{"account", "cloudflare_account", "script_name", "status"},
+	)
+	workersCPUTime = NewTimestampedMetricVec(
+		prometheus.GaugeValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "workers",
+			Name:      "cpu_time_seconds",
+			Help:      "Quantiles of the CPU time used by Workers invocations in the latest minute, by script and outcome.",
+		},
+		[]string{"account", "cloudflare_account", "script_name", "status", "quantile"},
+	)
+	workersWallTime = NewTimestampedMetricVec(
+		prometheus.GaugeValue,
+		prometheus.Opts{
+			Namespace: namespace,
+			Subsystem: "workers",
+			Name:      "wall_time_seconds",
+			Help:      "Quantiles of the wall time taken by Workers invocations in the latest minute, by script and outcome.",
+		},
+		[]string{"account", "cloudflare_account", "script_name", "status", "quantile"},
+	)
+	cloudflareAccountsActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "accounts",
+			Name:      "active",
+			Help:      "Number of Cloudflare accounts whose account-scoped datasets are scraped with the target credentials.",
+		},
+		[]string{"account"},
+	)
+	accountScrapeErrs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "account",
+			Name:      "scrape_errors_total",
+			Help:      "Number of times this exporter has failed to scrape a Cloudflare account's analytics dataset, by kind of error.",
+		},
+		[]string{"account", "cloudflare_account", "dataset", "kind"},
+	)
+	accountLastSuccessTimestampSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "account",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Time that a Cloudflare account's analytics dataset was last updated.",
+		},
+		[]string{"account", "cloudflare_account", "dataset"},
+	)
+	lbHealth = newLBHealthCollector()
+	lbHealthLastSuccessTimestampSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "lb",
+			Name:      "health_last_success_timestamp_seconds",
+			Help:      "Time that the health of load balancer pools and origins was last refreshed.",
+		},
+		[]string{"account"},
+	)
+
+	zoneChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "zones",
+			Name:      "changes_total",
+			Help:      "Number of zones that were added to or removed from the target Cloudflare account since startup.",
+		},
+		[]string{"account", "change"},
+	)
+	zoneScrapeErrs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "zone",
+			Name:      "scrape_errors_total",
+			Help:      "Number of times this exporter has failed to scrape a zone's analytics dataset, by kind of error.",
+		},
+		[]string{"account", "zone", "dataset", "kind"},
+	)
+	zoneLastSuccessTimestampSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "zone",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Time that a zone's analytics dataset was last updated.",
+		},
+		[]string{"account", "zone", "dataset"},
+	)
+
 	if reg == nil {
 		reg = prometheus.DefaultRegisterer
 	}
 	reg.MustRegister(zonesActive)
+	reg.MustRegister(zonesListed)
 	reg.MustRegister(httpCountryRequests)
 	reg.MustRegister(httpCountryThreats)
 	reg.MustRegister(httpCountryBytes)
@@ -172,7 +529,81 @@ func registerMetrics(reg prometheus.Registerer) {
 	reg.MustRegister(httpCachedBytes)
 	reg.MustRegister(firewallEvents)
 	reg.MustRegister(healthCheckEvents)
+	reg.MustRegister(loadBalancingRequests)
+	reg.MustRegister(dnsQueries)
+	reg.MustRegister(dnsQueryNameQueries)
+	reg.MustRegister(httpCacheStatusRequests)
+	reg.MustRegister(httpCacheStatusBytes)
+	reg.MustRegister(httpContentTypeCacheStatusRequests)
+	reg.MustRegister(httpEdgeTimeToFirstByte)
+	reg.MustRegister(httpOriginResponseDuration)
+	reg.MustRegister(httpEdgeDNSResponseTime)
+	reg.MustRegister(httpColoRequests)
+	reg.MustRegister(httpColoBytes)
+	reg.MustRegister(httpColo5xxResponses)
+	reg.MustRegister(httpColoUpperTierRequests)
 	reg.MustRegister(cfScrapes)
 	reg.MustRegister(cfScrapeErrs)
 	reg.MustRegister(cfLastSuccessTimestampSeconds)
+	reg.MustRegister(graphqlQueriesDeferred)
+	reg.MustRegister(apiRequests)
+	reg.MustRegister(apiRequestDuration)
+	reg.MustRegister(apiRequestRetries)
+	reg.MustRegister(credentialsLastReloadTimestampSeconds)
+	reg.MustRegister(datasetAvailable)
+	reg.MustRegister(zoneChanges)
+	reg.MustRegister(zoneScrapeErrs)
+	reg.MustRegister(zoneLastSuccessTimestampSeconds)
+	reg.MustRegister(workersRequests)
+	reg.MustRegister(workersErrors)
+	reg.MustRegister(workersSubrequests)
+	reg.MustRegister(workersCPUTime)
+	reg.MustRegister(workersWallTime)
+	reg.MustRegister(cloudflareAccountsActive)
+	reg.MustRegister(accountScrapeErrs)
+	reg.MustRegister(accountLastSuccessTimestampSeconds)
+	reg.MustRegister(lbHealth)
+	reg.MustRegister(lbHealthLastSuccessTimestampSeconds)
+}
+
+// zoneMetricVecs lists the metrics that have "account" and "zone" labels, whose
+// series are deleted when a zone is removed.
+func zoneMetricVecs() []*TimestampedMetricVec {
+	return []*TimestampedMetricVec{
+		httpCountryRequests, httpCountryThreats, httpCountryBytes, httpProtocolRequests, httpResponses, httpThreats,
+		httpCachedRequests, httpCachedBytes, firewallEvents, healthCheckEvents, loadBalancingRequests,
+		dnsQueries, dnsQueryNameQueries, httpCacheStatusRequests, httpCacheStatusBytes, httpContentTypeCacheStatusRequests,
+		httpEdgeTimeToFirstByte, httpOriginResponseDuration, httpEdgeDNSResponseTime, httpColoRequests, httpColoBytes,
+		httpColo5xxResponses, httpColoUpperTierRequests,
+	}
+}
+
+// accountMetricVecs lists the metrics of account-scoped datasets, which have
+// "account" and "cloudflare_account" labels, whose series are deleted when a
+// Cloudflare account is no longer accessible.
+func accountMetricVecs() []*TimestampedMetricVec {
+	return []*TimestampedMetricVec{workersRequests, workersErrors, workersSubrequests, workersCPUTime, workersWallTime}
+}
+
+// registerAccountMetrics registers metrics that are computed on demand from
+// per-account state.
+func registerAccountMetrics(reg prometheus.Registerer, accounts []*account) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, account := range accounts {
+		if account.queryBudget == nil {
+			continue
+		}
+		reg.MustRegister(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace:   namespace,
+				Subsystem:   "graphql",
+				Name:        "query_budget_remaining",
+				Help:        "Number of GraphQL queries that can be made before the query budget is exhausted",
+				ConstLabels: prometheus.Labels{"account": account.name},
+			},
+			account.queryBudget.remaining,
+		))
+	}
 }
@@ -3,27 +3,65 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func parseZoneIDs(apiRespBody io.Reader, zonesFilter []string) (map[string]string, error) {
+func parseZoneIDs(result json.RawMessage, filter zoneFilter) (map[string]string, error) {
 	var zoneList zonesResp
-	if err := json.NewDecoder(apiRespBody).Decode(&zoneList); err != nil {
+	if err := json.Unmarshal(result, &zoneList); err != nil {
 		return nil, err
 	}
 	zones := map[string]string{}
-	for _, zone := range zoneList.Result {
-		if zone.Status != "pending" && (len(zonesFilter) == 0 || contains(zonesFilter, zone.Name)) {
+	for _, zone := range zoneList {
+		if filter.matches(zone) {
 			zones[zone.ID] = zone.Name
 		}
 	}
 	return zones, nil
 }
 
-type extractFunc func(zoneResp, map[string]string, time.Time) (int, time.Time, error)
+func parseCloudflareAccounts(result json.RawMessage) (map[string]string, error) {
+	var accountList []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(result, &accountList); err != nil {
+		return nil, err
+	}
+	cloudflareAccounts := map[string]string{}
+	for _, cloudflareAccount := range accountList {
+		cloudflareAccounts[cloudflareAccount.ID] = cloudflareAccount.Name
+	}
+	return cloudflareAccounts, nil
+}
+
+// extractFunc records the results of a dataset for a zone or Cloudflare
+// account, named scopeName, that are newer than lastDateTimeCounted. It returns
+// how many results there were, and the time of the latest bucket counted.
+type extractFunc func(account string, resp scopeResp, scopeName string, lastDateTimeCounted time.Time) (int, time.Time, error)
+
+// pageCutoff returns the minute bucket that a full page of results, ordered by
+// time, may have been cut off in the middle of: its last. The groups of that
+// bucket are left for the next page, which carries on from the time counted up
+// to. Pages that are not full are not cut off, and the zero time is returned.
+func pageCutoff(results int, lastBucket func() string) (time.Time, error) {
+	if results < apiMaxLimit {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, lastBucket())
+}
+
+// countBucket reports whether a bucket is newer than the time counted up to
+// before this query, and older than the page's cutoff, if any. Datasets with
+// many groups per minute bucket compare each group with the time counted up to
+// before the query, not the latest bucket seen so far.
+func countBucket(bucketTime, lastDateTimeCounted, cutoff time.Time) bool {
+	return bucketTime.After(lastDateTimeCounted) && (cutoff.IsZero() || bucketTime.Before(cutoff))
+}
 
-func extractZoneHTTPRequests(zone zoneResp, zoneNames map[string]string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+func extractZoneHTTPRequests(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
 	for _, timeBucket := range zone.ReqGroups {
 		bucketTime, err := time.Parse(time.RFC3339, timeBucket.Dimensions.Datetime)
 		if err != nil {
@@ -33,29 +71,29 @@ func extractZoneHTTPRequests(zone zoneResp, zoneNames map[string]string, lastDat
 		if bucketTime.After(lastDateTimeCounted) {
 			lastDateTimeCounted = bucketTime
 			for _, countryData := range timeBucket.Sum.CountryMap {
-				httpCountryRequests.WithLabelValues(zoneNames[zone.ZoneTag], countryData.ClientCountryName).
+				httpCountryRequests.WithLabelValues(account, zoneName, countryData.ClientCountryName).
 					Add(float64(countryData.Requests), bucketTime)
-				httpCountryThreats.WithLabelValues(zoneNames[zone.ZoneTag], countryData.ClientCountryName).
+				httpCountryThreats.WithLabelValues(account, zoneName, countryData.ClientCountryName).
 					Add(float64(countryData.Threats), bucketTime)
-				httpCountryBytes.WithLabelValues(zoneNames[zone.ZoneTag], countryData.ClientCountryName).
+				httpCountryBytes.WithLabelValues(account, zoneName, countryData.ClientCountryName).
 					Add(float64(countryData.Bytes), bucketTime)
 			}
 
-			httpCachedRequests.WithLabelValues(zoneNames[zone.ZoneTag]).Add(float64(timeBucket.Sum.CachedRequests), bucketTime)
-			httpCachedBytes.WithLabelValues(zoneNames[zone.ZoneTag]).Add(float64(timeBucket.Sum.CachedBytes), bucketTime)
+			httpCachedRequests.WithLabelValues(account, zoneName).Add(float64(timeBucket.Sum.CachedRequests), bucketTime)
+			httpCachedBytes.WithLabelValues(account, zoneName).Add(float64(timeBucket.Sum.CachedBytes), bucketTime)
 
 			for _, httpVersionData := range timeBucket.Sum.ClientHTTPVersionMap {
-				httpProtocolRequests.WithLabelValues(zoneNames[zone.ZoneTag], httpVersionData.ClientHTTPProtocol).
+				httpProtocolRequests.WithLabelValues(account, zoneName, httpVersionData.ClientHTTPProtocol).
 					Add(float64(httpVersionData.Requests), bucketTime)
 			}
 
 			for _, responseStatusData := range timeBucket.Sum.ResponseStatusMap {
-				httpResponses.WithLabelValues(zoneNames[zone.ZoneTag], toString(responseStatusData.EdgeResponseStatus)).
+				httpResponses.WithLabelValues(account, zoneName, toString(responseStatusData.EdgeResponseStatus)).
 					Add(float64(responseStatusData.Requests), bucketTime)
 			}
 
 			for _, threatPathData := range timeBucket.Sum.ThreatPathingMap {
-				httpThreats.WithLabelValues(zoneNames[zone.ZoneTag], threatPathData.ThreatPathingName).
+				httpThreats.WithLabelValues(account, zoneName, threatPathData.ThreatPathingName).
 					Add(float64(threatPathData.Requests), bucketTime)
 			}
 		}
@@ -63,7 +101,7 @@ func extractZoneHTTPRequests(zone zoneResp, zoneNames map[string]string, lastDat
 	return len(zone.ReqGroups), lastDateTimeCounted, nil
 }
 
-func extractZoneFirewallEvents(zone zoneResp, zoneNames map[string]string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+func extractZoneFirewallEvents(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
 	for _, firewallEventGroup := range zone.FirewallEventsAdaptiveGroups {
 		eventTime, err := time.Parse(time.RFC3339, firewallEventGroup.Dimensions.Datetime)
 		if err != nil {
@@ -73,7 +111,7 @@ func extractZoneFirewallEvents(zone zoneResp, zoneNames map[string]string, lastD
 		if eventTime.After(lastDateTimeCounted) {
 			lastDateTimeCounted = eventTime
 			firewallEvents.WithLabelValues(
-				zoneNames[zone.ZoneTag], firewallEventGroup.Dimensions.Action,
+				account, zoneName, firewallEventGroup.Dimensions.Action,
 				firewallEventGroup.Dimensions.Source, firewallEventGroup.Dimensions.RuleID,
 				toString(firewallEventGroup.Dimensions.EdgeResponseStatus), toString(firewallEventGroup.Dimensions.OriginResponseStatus),
 			).Add(float64(firewallEventGroup.Count), eventTime)
@@ -82,7 +120,7 @@ func extractZoneFirewallEvents(zone zoneResp, zoneNames map[string]string, lastD
 	return len(zone.FirewallEventsAdaptiveGroups), lastDateTimeCounted, nil
 }
 
-func extractZoneHealthCheckEvents(zone zoneResp, zoneNames map[string]string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+func extractZoneHealthCheckEvents(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
 	for _, healthCheckEventsGroup := range zone.HealthCheckEventsGroups {
 		eventTime, err := time.Parse(time.RFC3339, healthCheckEventsGroup.Dimensions.Datetime)
 		if err != nil {
@@ -92,7 +130,7 @@ func extractZoneHealthCheckEvents(zone zoneResp, zoneNames map[string]string, la
 		if eventTime.After(lastDateTimeCounted) {
 			lastDateTimeCounted = eventTime
 			healthCheckEvents.WithLabelValues(
-				zoneNames[zone.ZoneTag], healthCheckEventsGroup.Dimensions.FailureReason,
+				account, zoneName, healthCheckEventsGroup.Dimensions.FailureReason,
 				healthCheckEventsGroup.Dimensions.HealthCheckName, healthCheckEventsGroup.Dimensions.HealthStatus,
 				toString(healthCheckEventsGroup.Dimensions.OriginResponseStatus),
 				healthCheckEventsGroup.Dimensions.Region, healthCheckEventsGroup.Dimensions.Scope,
@@ -102,13 +140,291 @@ func extractZoneHealthCheckEvents(zone zoneResp, zoneNames map[string]string, la
 	return len(zone.HealthCheckEventsGroups), lastDateTimeCounted, nil
 }
 
+// extractZoneLoadBalancingRequests records load balanced requests by the pool
+// and origin they were steered to. Many groups share each minute bucket, which
+// are counted as by countBucket.
+func extractZoneLoadBalancingRequests(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+	cutoff, err := pageCutoff(len(zone.LoadBalancingRequestsAdaptiveGroups), func() string {
+		return zone.LoadBalancingRequestsAdaptiveGroups[len(zone.LoadBalancingRequestsAdaptiveGroups)-1].Dimensions.DatetimeMinute
+	})
+	if err != nil {
+		return len(zone.LoadBalancingRequestsAdaptiveGroups), time.Time{}, err
+	}
+	latestDateTimeCounted := lastDateTimeCounted
+	for _, requestsGroup := range zone.LoadBalancingRequestsAdaptiveGroups {
+		bucketTime, err := time.Parse(time.RFC3339, requestsGroup.Dimensions.DatetimeMinute)
+		if err != nil {
+			return len(zone.LoadBalancingRequestsAdaptiveGroups), time.Time{}, err
+		}
+		if !countBucket(bucketTime, lastDateTimeCounted, cutoff) {
+			continue
+		}
+		if bucketTime.After(latestDateTimeCounted) {
+			latestDateTimeCounted = bucketTime
+		}
+		loadBalancingRequests.WithLabelValues(
+			account, zoneName, requestsGroup.Dimensions.LBName, requestsGroup.Dimensions.SelectedPoolName,
+			requestsGroup.Dimensions.SelectedOriginName, requestsGroup.Dimensions.SteeringPolicy,
+			strconv.FormatBool(requestsGroup.Dimensions.IsFallbackUsed != 0),
+		).Add(float64(requestsGroup.Count), bucketTime)
+	}
+	return len(zone.LoadBalancingRequestsAdaptiveGroups), latestDateTimeCounted, nil
+}
+
+// extractZoneDNSQueries records DNS queries by type and response code. Several
+// groups share each minute bucket, which are counted as by countBucket.
+func extractZoneDNSQueries(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+	cutoff, err := pageCutoff(len(zone.DNSAnalyticsAdaptiveGroups), func() string {
+		return zone.DNSAnalyticsAdaptiveGroups[len(zone.DNSAnalyticsAdaptiveGroups)-1].Dimensions.DatetimeMinute
+	})
+	if err != nil {
+		return len(zone.DNSAnalyticsAdaptiveGroups), time.Time{}, err
+	}
+	latestDateTimeCounted := lastDateTimeCounted
+	for _, queriesGroup := range zone.DNSAnalyticsAdaptiveGroups {
+		bucketTime, err := time.Parse(time.RFC3339, queriesGroup.Dimensions.DatetimeMinute)
+		if err != nil {
+			return len(zone.DNSAnalyticsAdaptiveGroups), time.Time{}, err
+		}
+		if !countBucket(bucketTime, lastDateTimeCounted, cutoff) {
+			continue
+		}
+		if bucketTime.After(latestDateTimeCounted) {
+			latestDateTimeCounted = bucketTime
+		}
+		dnsQueries.WithLabelValues(
+			account, zoneName, queriesGroup.Dimensions.QueryType, queriesGroup.Dimensions.ResponseCode,
+		).Add(float64(queriesGroup.Count), bucketTime)
+	}
+	return len(zone.DNSAnalyticsAdaptiveGroups), latestDateTimeCounted, nil
+}
+
+// extractZoneDNSQueryNames records DNS queries of the names counted separately
+// by name and response code. Several groups share each minute bucket, which
+// are counted as by countBucket.
+func extractZoneDNSQueryNames(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+	cutoff, err := pageCutoff(len(zone.DNSAnalyticsAdaptiveQueryNames), func() string {
+		return zone.DNSAnalyticsAdaptiveQueryNames[len(zone.DNSAnalyticsAdaptiveQueryNames)-1].Dimensions.DatetimeMinute
+	})
+	if err != nil {
+		return len(zone.DNSAnalyticsAdaptiveQueryNames), time.Time{}, err
+	}
+	latestDateTimeCounted := lastDateTimeCounted
+	for _, queriesGroup := range zone.DNSAnalyticsAdaptiveQueryNames {
+		bucketTime, err := time.Parse(time.RFC3339, queriesGroup.Dimensions.DatetimeMinute)
+		if err != nil {
+			return len(zone.DNSAnalyticsAdaptiveQueryNames), time.Time{}, err
+		}
+		if !countBucket(bucketTime, lastDateTimeCounted, cutoff) {
+			continue
+		}
+		if bucketTime.After(latestDateTimeCounted) {
+			latestDateTimeCounted = bucketTime
+		}
+		dnsQueryNameQueries.WithLabelValues(
+			account, zoneName, strings.ToLower(strings.TrimSuffix(queriesGroup.Dimensions.QueryName, ".")),
+			queriesGroup.Dimensions.ResponseCode,
+		).Add(float64(queriesGroup.Count), bucketTime)
+	}
+	return len(zone.DNSAnalyticsAdaptiveQueryNames), latestDateTimeCounted, nil
+}
+
+// extractZoneHTTPCacheStatus records requests and bytes by cache status and,
+// if they are counted by it, requests by cache status and content type.
+// Several groups share each minute bucket, which are counted as by countBucket.
+func (e *exporter) extractZoneHTTPCacheStatus(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+	cutoff, err := pageCutoff(len(zone.HTTPRequestsAdaptiveCacheStatus), func() string {
+		return zone.HTTPRequestsAdaptiveCacheStatus[len(zone.HTTPRequestsAdaptiveCacheStatus)-1].Dimensions.DatetimeMinute
+	})
+	if err != nil {
+		return len(zone.HTTPRequestsAdaptiveCacheStatus), time.Time{}, err
+	}
+	latestDateTimeCounted := lastDateTimeCounted
+	for _, requestsGroup := range zone.HTTPRequestsAdaptiveCacheStatus {
+		bucketTime, err := time.Parse(time.RFC3339, requestsGroup.Dimensions.DatetimeMinute)
+		if err != nil {
+			return len(zone.HTTPRequestsAdaptiveCacheStatus), time.Time{}, err
+		}
+		if !countBucket(bucketTime, lastDateTimeCounted, cutoff) {
+			continue
+		}
+		if bucketTime.After(latestDateTimeCounted) {
+			latestDateTimeCounted = bucketTime
+		}
+		cacheStatus := requestsGroup.Dimensions.CacheStatus
+		httpCacheStatusRequests.WithLabelValues(account, zoneName, cacheStatus).Add(float64(requestsGroup.Count), bucketTime)
+		httpCacheStatusBytes.WithLabelValues(account, zoneName, cacheStatus).Add(float64(requestsGroup.Sum.EdgeResponseBytes), bucketTime)
+		if e.cacheStatusByContentType {
+			httpContentTypeCacheStatusRequests.WithLabelValues(
+				account, zoneName, requestsGroup.Dimensions.EdgeResponseContentTypeName, cacheStatus,
+			).Add(float64(requestsGroup.Count), bucketTime)
+		}
+	}
+	return len(zone.HTTPRequestsAdaptiveCacheStatus), latestDateTimeCounted, nil
+}
+
+// extractZoneHTTPLatency records quantiles of the time taken by HTTP requests
+// in each minute bucket, converted from milliseconds.
+func extractZoneHTTPLatency(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+	for _, requestsGroup := range zone.HTTPRequestsAdaptiveLatency {
+		bucketTime, err := time.Parse(time.RFC3339, requestsGroup.Dimensions.DatetimeMinute)
+		if err != nil {
+			return len(zone.HTTPRequestsAdaptiveLatency), time.Time{}, err
+		}
+		if !bucketTime.After(lastDateTimeCounted) {
+			continue
+		}
+		lastDateTimeCounted = bucketTime
+
+		quantiles := requestsGroup.Quantiles
+		for metric, quantileValues := range map[*TimestampedMetricVec]map[string]float64{
+			httpEdgeTimeToFirstByte: {
+				"0.5": quantiles.EdgeTimeToFirstByteMsP50, "0.9": quantiles.EdgeTimeToFirstByteMsP90,
+				"0.99": quantiles.EdgeTimeToFirstByteMsP99,
+			},
+			httpOriginResponseDuration: {
+				"0.5": quantiles.OriginResponseDurationMsP50, "0.9": quantiles.OriginResponseDurationMsP90,
+				"0.99": quantiles.OriginResponseDurationMsP99,
+			},
+			httpEdgeDNSResponseTime: {
+				"0.5": quantiles.EdgeDNSResponseTimeMsP50, "0.9": quantiles.EdgeDNSResponseTimeMsP90,
+				"0.99": quantiles.EdgeDNSResponseTimeMsP99,
+			},
+		} {
+			for quantile, value := range quantileValues {
+				metric.WithLabelValues(account, zoneName, quantile).Set(value/1e3, bucketTime)
+			}
+		}
+	}
+	return len(zone.HTTPRequestsAdaptiveLatency), lastDateTimeCounted, nil
+}
+
+// extractZoneHTTPColos records requests and bytes by the colo that served them
+// and, if they are counted by it, requests by colo and upper tier colo. Colos
+// that are not counted separately are counted as "other". Several groups share
+// each minute bucket, which are counted as by countBucket.
+func (e *exporter) extractZoneHTTPColos(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+	cutoff, err := pageCutoff(len(zone.HTTPRequestsAdaptiveColos), func() string {
+		return zone.HTTPRequestsAdaptiveColos[len(zone.HTTPRequestsAdaptiveColos)-1].Dimensions.DatetimeMinute
+	})
+	if err != nil {
+		return len(zone.HTTPRequestsAdaptiveColos), time.Time{}, err
+	}
+	latestDateTimeCounted := lastDateTimeCounted
+	for _, requestsGroup := range zone.HTTPRequestsAdaptiveColos {
+		bucketTime, err := time.Parse(time.RFC3339, requestsGroup.Dimensions.DatetimeMinute)
+		if err != nil {
+			return len(zone.HTTPRequestsAdaptiveColos), time.Time{}, err
+		}
+		if !countBucket(bucketTime, lastDateTimeCounted, cutoff) {
+			continue
+		}
+		if bucketTime.After(latestDateTimeCounted) {
+			latestDateTimeCounted = bucketTime
+		}
+
+		colo := e.coloLabel(requestsGroup.Dimensions.ColoCode)
+		httpColoRequests.WithLabelValues(account, zoneName, colo).Add(float64(requestsGroup.Count), bucketTime)
+		httpColoBytes.WithLabelValues(account, zoneName, colo).Add(float64(requestsGroup.Sum.EdgeResponseBytes), bucketTime)
+		if e.colosByUpperTier {
+			httpColoUpperTierRequests.WithLabelValues(
+				account, zoneName, colo, e.coloLabel(requestsGroup.Dimensions.UpperTierColoName),
+			).Add(float64(requestsGroup.Count), bucketTime)
+		}
+	}
+	return len(zone.HTTPRequestsAdaptiveColos), latestDateTimeCounted, nil
+}
+
+// extractZoneHTTPColo5xx records 5xx responses by the colo that served them,
+// labelled as by extractZoneHTTPColos. Colos that served none in a minute are
+// not returned for it.
+func (e *exporter) extractZoneHTTPColo5xx(account string, zone scopeResp, zoneName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+	cutoff, err := pageCutoff(len(zone.HTTPRequestsAdaptiveColo5xx), func() string {
+		return zone.HTTPRequestsAdaptiveColo5xx[len(zone.HTTPRequestsAdaptiveColo5xx)-1].Dimensions.DatetimeMinute
+	})
+	if err != nil {
+		return len(zone.HTTPRequestsAdaptiveColo5xx), time.Time{}, err
+	}
+	latestDateTimeCounted := lastDateTimeCounted
+	for _, responsesGroup := range zone.HTTPRequestsAdaptiveColo5xx {
+		bucketTime, err := time.Parse(time.RFC3339, responsesGroup.Dimensions.DatetimeMinute)
+		if err != nil {
+			return len(zone.HTTPRequestsAdaptiveColo5xx), time.Time{}, err
+		}
+		if !countBucket(bucketTime, lastDateTimeCounted, cutoff) {
+			continue
+		}
+		if bucketTime.After(latestDateTimeCounted) {
+			latestDateTimeCounted = bucketTime
+		}
+		httpColo5xxResponses.WithLabelValues(account, zoneName, e.coloLabel(responsesGroup.Dimensions.ColoCode)).
+			Add(float64(responsesGroup.Count), bucketTime)
+	}
+	return len(zone.HTTPRequestsAdaptiveColo5xx), latestDateTimeCounted, nil
+}
+
+// coloLabel returns a colo code, or "other" if it is not counted separately.
+// Requests that did not go through an upper tier have no upper tier colo.
+func (e *exporter) coloLabel(colo string) string {
+	if colo == "" || len(e.colos) == 0 || e.colos[strings.ToUpper(colo)] {
+		return colo
+	}
+	return "other"
+}
+
+// extractAccountWorkersInvocations records Workers invocations by script and
+// status. Several scripts and statuses share each minute bucket, which are
+// counted as by countBucket.
+func extractAccountWorkersInvocations(account string, cloudflareAccount scopeResp, cloudflareAccountName string, lastDateTimeCounted time.Time) (int, time.Time, error) {
+	cutoff, err := pageCutoff(len(cloudflareAccount.WorkersInvocationsAdaptive), func() string {
+		return cloudflareAccount.WorkersInvocationsAdaptive[len(cloudflareAccount.WorkersInvocationsAdaptive)-1].Dimensions.DatetimeMinute
+	})
+	if err != nil {
+		return len(cloudflareAccount.WorkersInvocationsAdaptive), time.Time{}, err
+	}
+	latestDateTimeCounted := lastDateTimeCounted
+	for _, invocationsGroup := range cloudflareAccount.WorkersInvocationsAdaptive {
+		bucketTime, err := time.Parse(time.RFC3339, invocationsGroup.Dimensions.DatetimeMinute)
+		if err != nil {
+			return len(cloudflareAccount.WorkersInvocationsAdaptive), time.Time{}, err
+		}
+		if !countBucket(bucketTime, lastDateTimeCounted, cutoff) {
+			continue
+		}
+		if bucketTime.After(latestDateTimeCounted) {
+			latestDateTimeCounted = bucketTime
+		}
+
+		labels := []string{
+			account, cloudflareAccountName, invocationsGroup.Dimensions.ScriptName, invocationsGroup.Dimensions.Status,
+		}
+		workersRequests.WithLabelValues(labels...).Add(float64(invocationsGroup.Sum.Requests), bucketTime)
+		workersErrors.WithLabelValues(labels...).Add(float64(invocationsGroup.Sum.Errors), bucketTime)
+		workersSubrequests.WithLabelValues(labels...).Add(float64(invocationsGroup.Sum.Subrequests), bucketTime)
+		// Quantiles are reported in microseconds.
+		for quantile, cpuTime := range map[string]float64{
+			"0.5": invocationsGroup.Quantiles.CPUTimeP50, "0.99": invocationsGroup.Quantiles.CPUTimeP99,
+		} {
+			workersCPUTime.WithLabelValues(append(labels, quantile)...).Set(cpuTime/1e6, bucketTime)
+		}
+		for quantile, wallTime := range map[string]float64{
+			"0.5": invocationsGroup.Quantiles.WallTimeP50, "0.99": invocationsGroup.Quantiles.WallTimeP99,
+		} {
+			workersWallTime.WithLabelValues(append(labels, quantile)...).Set(wallTime/1e6, bucketTime)
+		}
+	}
+	return len(cloudflareAccount.WorkersInvocationsAdaptive), latestDateTimeCounted, nil
+}
+
 type cloudflareResp struct {
 	Viewer struct {
-		Zones []zoneResp `json:"zones"`
+		Zones    []scopeResp `json:"zones"`
+		Accounts []scopeResp `json:"accounts"`
 	} `json:"viewer"`
 }
 
-type zoneResp struct {
+// scopeResp holds the datasets queried for a zone or a Cloudflare account.
+type scopeResp struct {
 	ReqGroups []struct {
 		Dimensions struct {
 			Datetime string `json:"datetime"`
@@ -162,15 +478,126 @@ type zoneResp struct {
 		} `json:"dimensions"`
 	} `json:"healthCheckEventsGroups"`
 
-	ZoneTag string `json:"zoneTag"`
+	LoadBalancingRequestsAdaptiveGroups []struct {
+		Count      uint64 `json:"count"`
+		Dimensions struct {
+			DatetimeMinute     string `json:"datetimeMinute"`
+			LBName             string `json:"lbName"`
+			SelectedPoolName   string `json:"selectedPoolName"`
+			SelectedOriginName string `json:"selectedOriginName"`
+			SteeringPolicy     string `json:"steeringPolicy"`
+			IsFallbackUsed     uint8  `json:"isFallbackUsed"`
+		} `json:"dimensions"`
+	} `json:"loadBalancingRequestsAdaptiveGroups"`
+
+	DNSAnalyticsAdaptiveGroups []struct {
+		Count      uint64 `json:"count"`
+		Dimensions struct {
+			DatetimeMinute string `json:"datetimeMinute"`
+			QueryType      string `json:"queryType"`
+			ResponseCode   string `json:"responseCode"`
+		} `json:"dimensions"`
+	} `json:"dnsAnalyticsAdaptiveGroups"`
+
+	DNSAnalyticsAdaptiveQueryNames []struct {
+		Count      uint64 `json:"count"`
+		Dimensions struct {
+			DatetimeMinute string `json:"datetimeMinute"`
+			QueryName      string `json:"queryName"`
+			ResponseCode   string `json:"responseCode"`
+		} `json:"dimensions"`
+	} `json:"dnsAnalyticsAdaptiveQueryNames"`
+
+	HTTPRequestsAdaptiveCacheStatus []struct {
+		Count      uint64 `json:"count"`
+		Dimensions struct {
+			DatetimeMinute              string `json:"datetimeMinute"`
+			CacheStatus                 string `json:"cacheStatus"`
+			EdgeResponseContentTypeName string `json:"edgeResponseContentTypeName"`
+		} `json:"dimensions"`
+		Sum struct {
+			EdgeResponseBytes uint64 `json:"edgeResponseBytes"`
+		} `json:"sum"`
+	} `json:"httpRequestsAdaptiveCacheStatus"`
+
+	HTTPRequestsAdaptiveLatency []struct {
+		Dimensions struct {
+			DatetimeMinute string `json:"datetimeMinute"`
+		} `json:"dimensions"`
+		Quantiles struct {
+			EdgeTimeToFirstByteMsP50    float64 `json:"edgeTimeToFirstByteMsP50"`
+			EdgeTimeToFirstByteMsP90    float64 `json:"edgeTimeToFirstByteMsP90"`
+			EdgeTimeToFirstByteMsP99    float64 `json:"edgeTimeToFirstByteMsP99"`
+			OriginResponseDurationMsP50 float64 `json:"originResponseDurationMsP50"`
+			OriginResponseDurationMsP90 float64 `json:"originResponseDurationMsP90"`
+			OriginResponseDurationMsP99 float64 `json:"originResponseDurationMsP99"`
+			EdgeDNSResponseTimeMsP50    float64 `json:"edgeDnsResponseTimeMsP50"`
+			EdgeDNSResponseTimeMsP90    float64 `json:"edgeDnsResponseTimeMsP90"`
+			EdgeDNSResponseTimeMsP99    float64 `json:"edgeDnsResponseTimeMsP99"`
+		} `json:"quantiles"`
+	} `json:"httpRequestsAdaptiveLatency"`
+
+	HTTPRequestsAdaptiveColos []struct {
+		Count      uint64 `json:"count"`
+		Dimensions struct {
+			DatetimeMinute    string `json:"datetimeMinute"`
+			ColoCode          string `json:"coloCode"`
+			UpperTierColoName string `json:"upperTierColoName"`
+		} `json:"dimensions"`
+		Sum struct {
+			EdgeResponseBytes uint64 `json:"edgeResponseBytes"`
+		} `json:"sum"`
+	} `json:"httpRequestsAdaptiveColos"`
+
+	HTTPRequestsAdaptiveColo5xx []struct {
+		Count      uint64 `json:"count"`
+		Dimensions struct {
+			DatetimeMinute string `json:"datetimeMinute"`
+			ColoCode       string `json:"coloCode"`
+		} `json:"dimensions"`
+	} `json:"httpRequestsAdaptiveColo5xx"`
+
+	WorkersInvocationsAdaptive []struct {
+		Dimensions struct {
+			DatetimeMinute string `json:"datetimeMinute"`
+			ScriptName     string `json:"scriptName"`
+			Status         string `json:"status"`
+		} `json:"dimensions"`
+		Sum struct {
+			Requests    uint64 `json:"requests"`
+			Errors      uint64 `json:"errors"`
+			Subrequests uint64 `json:"subrequests"`
+		} `json:"sum"`
+		Quantiles struct {
+			CPUTimeP50  float64 `json:"cpuTimeP50"`
+			CPUTimeP99  float64 `json:"cpuTimeP99"`
+			WallTimeP50 float64 `json:"wallTimeP50"`
+			WallTimeP99 float64 `json:"wallTimeP99"`
+		} `json:"quantiles"`
+	} `json:"workersInvocationsAdaptive"`
+
+	ZoneTag    string `json:"zoneTag"`
+	AccountTag string `json:"accountTag"`
+}
+
+type zonesResp []zoneInfo
+
+type zoneInfo struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Paused bool   `json:"paused"`
+	Plan   struct {
+		LegacyID string `json:"legacy_id"`
+	} `json:"plan"`
 }
 
-type zonesResp struct {
-	Result []struct {
-		ID     string `json:"id"`
-		Name   string `json:"name"`
-		Status string `json:"status"`
-	} `json:"result"`
+type resultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
 }
 
 func toString(i int) string {
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// startupChecks runs the enabled preflight checks and GraphQL schema
+// validation, each within timeout, before the first scrape. The listener is
+// already serving /metrics by then, so that slow checks do not fail liveness
+// probes. Failures are logged, and only returned, to stop the exporter, if
+// strict.
+func (e *exporter) startupChecks(
+	ctx context.Context, runPreflight, validateSchema, strict bool, timeout time.Duration,
+) error {
+	var errs scrapeErrors
+	if runPreflight {
+		preflightCtx, cancel := context.WithTimeout(ctx, timeout)
+		// Each problem found has been logged already.
+		if err := e.preflight(preflightCtx); err != nil {
+			errs.add(err)
+		}
+		cancel()
+	}
+	if validateSchema {
+		schemaCtx, cancel := context.WithTimeout(ctx, timeout)
+		if err := e.validateGraphqlSchema(schemaCtx); err != nil {
+			level.Error(e.logger).Log("msg", "GraphQL schema validation failed", "error", err)
+			errs.add(err)
+		}
+		cancel()
+	}
+	if !strict {
+		return nil
+	}
+	return errs.err()
+}
+
+// preflight checks each account's credentials, that its zones can be listed,
+// and which datasets can be queried, so that misconfiguration is
+// reported at startup rather than as scrape errors minutes later. Every
+// problem found is logged, with a hint at how to fix it, and summarised in the
+// returned error.
+func (e *exporter) preflight(ctx context.Context) error {
+	var problems []string
+	for _, account := range e.accounts {
+		problems = append(problems, e.preflightAccount(ctx, account)...)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("preflight checks failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (e *exporter) preflightAccount(ctx context.Context, account *account) []string {
+	logger := log.With(e.logger, "event", "preflight", "account", account.name)
+
+	if err := e.verifyCredentials(ctx, account); err != nil {
+		level.Error(logger).Log("msg", "credentials were not accepted", "error", err, "hint", preflightHint(err, ""))
+		return []string{fmt.Sprintf("account %s: verifying credentials: %s", account.name, err)}
+	}
+	level.Info(logger).Log("msg", "credentials verified")
+
+	zones, err := e.getZones(ctx, account)
+	if err != nil {
+		level.Error(logger).Log("msg", "listing zones failed", "error", err, "hint", preflightHint(err, "Zone:Read"))
+		return []string{fmt.Sprintf("account %s: listing zones: %s", account.name, err)}
+	}
+	if len(zones) == 0 {
+		level.Error(logger).Log("msg", "no zones to scrape", "hint", "check the zones filter, and that the credentials can access the zones")
+		return []string{fmt.Sprintf("account %s: no zones to scrape", account.name)}
+	}
+	level.Info(logger).Log("msg", "listed zones", "zones", len(zones))
+
+	// Each dataset is probed once, with the first of the zones, rather than for
+	// every zone, which would take as many queries as a scrape.
+	zoneIDs := make([]string, 0, len(zones))
+	for zoneID := range zones {
+		zoneIDs = append(zoneIDs, zoneID)
+	}
+	sort.Strings(zoneIDs)
+	zoneID := zoneIDs[0]
+
+	e.scrapeLock.Lock()
+	datasets := e.zoneDatasets(account)
+	e.scrapeLock.Unlock()
+
+	var problems []string
+	for _, dataset := range datasets {
+		err := e.probeZoneDataset(ctx, account, zones, zoneID, dataset)
+		if ctx.Err() != nil {
+			// Once the time allowed runs out, the remaining datasets are left
+			// for scrapes to report.
+			level.Warn(logger).Log("msg", "skipping remaining dataset checks", "error", err)
+			return problems
+		}
+		if err != nil {
+			datasetAvailable.WithLabelValues(account.name, zones[zoneID], dataset.name).Set(0)
+			level.Error(logger).Log(
+				"msg", "dataset is unavailable", "zone", zones[zoneID], "dataset", dataset.name, "error", err,
+				"hint", preflightHint(err, "Analytics:Read"),
+			)
+			problems = append(problems, fmt.Sprintf(
+				"account %s: zone %s: dataset %s is unavailable: %s", account.name, zones[zoneID], dataset.name, err,
+			))
+			continue
+		}
+		datasetAvailable.WithLabelValues(account.name, zones[zoneID], dataset.name).Set(1)
+	}
+	return problems
+}
+
+// verifyCredentials checks API tokens with the token verification endpoint,
+// and API keys by looking up their user.
+func (e *exporter) verifyCredentials(ctx context.Context, account *account) error {
+	if !account.credentials.usesAPIToken() {
+		var user struct {
+			ID string `json:"id"`
+		}
+		_, err := e.restClient.get(ctx, account, "rest:user", "/user", nil, &user)
+		return err
+	}
+
+	var token struct {
+		Status string `json:"status"`
+	}
+	if _, err := e.restClient.get(ctx, account, "rest:user:tokens:verify", "/user/tokens/verify", nil, &token); err != nil {
+		return err
+	}
+	if token.Status != "active" {
+		return &apiError{api: "rest", kind: errorKindAuthentication, message: fmt.Sprintf("API token is %s", token.Status)}
+	}
+	return nil
+}
+
+// probeZoneDataset makes the smallest possible query of a dataset for a zone.
+// Probes are not charged to the account's query budget, which paces scrapes.
+func (e *exporter) probeZoneDataset(
+	ctx context.Context, account *account, zones map[string]string, zoneID string, dataset analyticsDataset,
+) error {
+	unbudgeted := *account
+	unbudgeted.queryBudget = nil
+	req := newGraphqlRequest(zoneGqlQuery(dataset.selection))
+	req.Var("zone", zoneID)
+	req.Var("start_time", time.Now().UTC().Add(-time.Minute))
+	req.Var("limit", 1)
+	var gqlResp cloudflareResp
+	return e.makeGraphqlRequest(ctx, log.With(e.logger), dataset.requestKind, &unbudgeted, zones[zoneID], req, &gqlResp)
+}
+
+// preflightHint suggests how to fix a failed check, given the permission it
+// needs.
+func preflightHint(err error, permission string) string {
+	switch errorKindOf(err) {
+	case errorKindAuthentication:
+		return "check that the API token, or API email and key, are correct and have not expired or been revoked"
+	case errorKindAuthorization:
+		if permission == "" {
+			return "check the IP address and time restrictions of the API token"
+		}
+		if permission == "Analytics:Read" {
+			return "grant the credentials the Analytics:Read permission on this zone, and check that the zone's plan includes this dataset"
+		}
+		return fmt.Sprintf("grant the credentials the %s permission", permission)
+	case errorKindRateLimited:
+		return "the account is rate limited, retry later"
+	case errorKindQueryInvalid:
+		return "the dataset may have been removed from the GraphQL API, or not be available on the zone's plan"
+	default:
+		return "check connectivity to the Cloudflare API"
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPreflightServer(t *testing.T, tokenStatus string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user/tokens/verify":
+			_, _ = w.Write([]byte(`{"success": true, "result": {"id": "a-token-id", "status": "` + tokenStatus + `"}}`))
+		case "/zones":
+			_, _ = w.Write([]byte(`{
+				"success": true,
+				"result": [
+					{"id": "b-zone", "name": "b-zone-name", "status": "active"},
+					{"id": "a-zone", "name": "a-zone-name", "status": "active"}
+				],
+				"result_info": {"page": 1, "per_page": 50, "total_pages": 1, "count": 2, "total_count": 2}
+			}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestPreflight_ReportsUnavailableDatasets(t *testing.T) {
+	server := newPreflightServer(t, "active")
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	graphqlClient := newFakeGraphqlClient([]string{
+		"empty_http_reqs_resp.json", "combined_authz_error_resp.json", "empty_http_reqs_resp.json",
+	})
+	// An empty budget, that only refills once an hour: the probes are not
+	// charged to it.
+	budget := newQueryBudget(1, time.Second)
+	budget.refillPerSecond = 1.0 / 3600
+	budget.tokens = 0
+	cfExporter := exporter{
+		scrapeLock: &sync.Mutex{},
+		accounts: []*account{
+			{name: "an-account", credentials: credentials{apiToken: "a-token"}, queryBudget: budget},
+		},
+		restClient:    newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error")),
+		graphqlClient: graphqlClient,
+		logger:        newPromLogger("error"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err := cfExporter.preflight(ctx)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "zone a-zone-name: dataset firewallEventsAdaptiveGroups is unavailable")
+
+	for dataset, available := range map[string]float64{
+		"httpRequests1mGroups":         1,
+		"firewallEventsAdaptiveGroups": 0,
+		"healthCheckEventsGroups":      1,
+	} {
+		assert.Equal(t, available, testutil.ToFloat64(datasetAvailable.WithLabelValues("an-account", "a-zone-name", dataset)), dataset)
+	}
+	// Each dataset is checked once, with the first zone.
+	require.Len(t, graphqlClient.requests, 3)
+	for _, req := range graphqlClient.requests {
+		assert.Equal(t, "a-zone", req.vars["zone"])
+		assert.Equal(t, 1, req.vars["limit"])
+	}
+	assert.Equal(t, 3, testutil.CollectAndCount(datasetAvailable))
+}
+
+func TestPreflight_RejectsInactiveToken(t *testing.T) {
+	server := newPreflightServer(t, "disabled")
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	cfExporter := exporter{
+		scrapeLock: &sync.Mutex{},
+		accounts:   []*account{{name: "an-account", credentials: credentials{apiToken: "a-token"}}},
+		restClient: newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error")),
+		logger:     newPromLogger("error"),
+	}
+
+	err := cfExporter.preflight(context.Background())
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "account an-account: verifying credentials: rest: API token is disabled (authentication)")
+}
+
+func TestStartupChecks_FailOnlyIfStrict(t *testing.T) {
+	server := newPreflightServer(t, "disabled")
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	cfExporter := exporter{
+		scrapeLock: &sync.Mutex{},
+		accounts:   []*account{{name: "an-account", credentials: credentials{apiToken: "a-token"}}},
+		restClient: newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error")),
+		logger:     newPromLogger("error"),
+	}
+
+	assert.Nil(t, cfExporter.startupChecks(context.Background(), true, false, false, time.Minute))
+	err := cfExporter.startupChecks(context.Background(), true, false, true, time.Minute)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "verifying credentials")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Cloudflare limits how many GraphQL queries each user may make in a rolling
+// window: https://developers.cloudflare.com/analytics/graphql-api/limits/
+const queryBudgetWindow = 5 * time.Minute
+
+var errQueryBudgetExhausted = errors.New("GraphQL query budget exhausted")
+
+// queryBudget is a token bucket that keeps an account's GraphQL queries within
+// Cloudflare's rate limit. Tokens refill continuously at budget/window, and at
+// most one scrape interval's worth can accumulate, so that queries are spread
+// across scrapes rather than bursting after a quiet period. Within a scrape,
+// queries are paced evenly up to its deadline. A nil *queryBudget places no
+// limit on queries.
+type queryBudget struct {
+	lock            sync.Mutex
+	capacity        float64
+	tokens          float64
+	refillPerSecond float64
+	updated         time.Time
+	// spacing is the time to leave between queries, and next the earliest
+	// time of the next query.
+	spacing time.Duration
+	next    time.Time
+	now     func() time.Time
+}
+
+func newQueryBudget(queriesPerWindow int, scrapeInterval time.Duration) *queryBudget {
+	refillPerSecond := float64(queriesPerWindow) / queryBudgetWindow.Seconds()
+	capacity := refillPerSecond * scrapeInterval.Seconds()
+	if capacity > float64(queriesPerWindow) {
+		capacity = float64(queriesPerWindow)
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &queryBudget{
+		capacity: capacity, tokens: capacity, refillPerSecond: refillPerSecond,
+		updated: time.Now(), now: time.Now,
+	}
+}
+
+// pace spreads the given number of queries evenly between now and ctx's
+// deadline, the first of them straight away.
+func (b *queryBudget) pace(ctx context.Context, queries int) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.spacing, b.next = 0, b.now()
+	if deadline, ok := ctx.Deadline(); ok && queries > 0 {
+		b.spacing = deadline.Sub(b.now()) / time.Duration(queries)
+	}
+}
+
+// take consumes a token, waiting for one to become available, and for the
+// query's turn as paced, if necessary. If no token would be available before
+// ctx's deadline, it returns errQueryBudgetExhausted immediately. Queries
+// beyond those paced, such as further pages of results, go as soon as there
+// is a token once the deadline is too near to wait for their turn.
+func (b *queryBudget) take(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	b.refill()
+	now := b.now()
+	b.tokens--
+	wait := time.Duration(0)
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.refillPerSecond * float64(time.Second))
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline && now.Add(wait).After(deadline) {
+		b.tokens++
+		b.lock.Unlock()
+		return errQueryBudgetExhausted
+	}
+	if turn := b.next.Sub(now); turn > wait && (!hasDeadline || !now.Add(turn).After(deadline)) {
+		wait = turn
+	}
+	b.next = now.Add(wait + b.spacing)
+	b.lock.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		// The query will not be made, so it should not count against the
+		// budget.
+		b.lock.Lock()
+		b.tokens++
+		b.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// exhaust empties the bucket, for when Cloudflare tells us that we are rate
+// limited despite our accounting.
+func (b *queryBudget) exhaust() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	if b.tokens > 0 {
+		b.tokens = 0
+	}
+}
+
+func (b *queryBudget) remaining() float64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	if b.tokens < 0 {
+		return 0
+	}
+	return b.tokens
+}
+
+func (b *queryBudget) refill() {
+	now := b.now()
+	b.tokens += now.Sub(b.updated).Seconds() * b.refillPerSecond
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.updated = now
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryBudget(t *testing.T) {
+	now := fixedTime
+	budget := newQueryBudget(300, time.Minute)
+	budget.now = func() time.Time { return now }
+	budget.updated = now
+
+	// 300 queries per 5 minutes is 1 per second, so a 1 minute scrape interval
+	// may accumulate 60 of them.
+	assert.Equal(t, 60.0, budget.remaining())
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Millisecond))
+	defer cancel()
+	for i := 0; i < 60; i++ {
+		require.Nil(t, budget.take(ctx))
+	}
+	assert.Equal(t, 0.0, budget.remaining())
+	assert.Equal(t, errQueryBudgetExhausted, budget.take(ctx))
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, 10.0, budget.remaining())
+
+	budget.exhaust()
+	assert.Equal(t, 0.0, budget.remaining())
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, 60.0, budget.remaining())
+}
+
+func TestQueryBudget_PacesQueries(t *testing.T) {
+	now := fixedTime
+	budget := newQueryBudget(300, time.Minute)
+	budget.now = func() time.Time { return now }
+	budget.updated = now
+
+	// The deadline has long passed in real time, so any wait is cut short.
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(10*time.Second))
+	defer cancel()
+	budget.pace(ctx, 5)
+	require.Nil(t, budget.take(ctx))
+
+	// The next query's turn is 2 seconds later. The token of a query that is
+	// cancelled while waiting for it is refunded.
+	assert.Equal(t, context.DeadlineExceeded, budget.take(ctx))
+	assert.Equal(t, 59.0, budget.remaining())
+
+	now = now.Add(4 * time.Second)
+	require.Nil(t, budget.take(ctx))
+
+	// Queries beyond those paced don't wait for a turn after the deadline.
+	now = now.Add(5 * time.Second)
+	require.Nil(t, budget.take(ctx))
+	require.Nil(t, budget.take(ctx))
+}
+
+func TestQueryBudget_Nil(t *testing.T) {
+	var budget *queryBudget
+	budget.pace(context.Background(), 1)
+	assert.Nil(t, budget.take(context.Background()))
+	budget.exhaust()
+}
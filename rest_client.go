@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// restClient makes requests to the Cloudflare REST API on behalf of accounts.
+// It unwraps the response envelope, returning *apiError for unsuccessful
+// responses whatever their status code, and pages through list endpoints.
+type restClient struct {
+	baseURL     string
+	httpClient  *http.Client
+	retryPolicy retryPolicy
+	logger      log.Logger
+}
+
+func newRESTClient(baseURL string, httpClient *http.Client, retryPolicy retryPolicy, logger log.Logger) *restClient {
+	return &restClient{baseURL: baseURL, httpClient: httpClient, retryPolicy: retryPolicy, logger: logger}
+}
+
+// restResponse is the envelope of every REST API response.
+type restResponse struct {
+	Success    bool            `json:"success"`
+	Errors     []restError     `json:"errors"`
+	Messages   []restError     `json:"messages"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo resultInfo      `json:"result_info"`
+}
+
+// get requests path, relative to the base URL, decoding the response's result
+// into result.
+func (c *restClient) get(
+	ctx context.Context, account *account, requestKind, path string, query url.Values, result interface{},
+) (resultInfo, error) {
+	restResp, err := c.do(ctx, account, requestKind, path, query)
+	if err != nil {
+		return resultInfo{}, err
+	}
+	if err := json.Unmarshal(restResp.Result, result); err != nil {
+		return resultInfo{}, fmt.Errorf("decoding %s result: %w", requestKind, err)
+	}
+	return restResp.ResultInfo, nil
+}
+
+// getPages requests every page of a list at path, passing the result of each
+// to handle in turn, and returns the result info of the last page.
+func (c *restClient) getPages(
+	ctx context.Context, account *account, requestKind, path string, query url.Values, perPage int,
+	handle func(json.RawMessage) error,
+) (resultInfo, error) {
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return resultInfo{}, err
+		}
+		pageQuery := url.Values{}
+		for key, values := range query {
+			pageQuery[key] = values
+		}
+		pageQuery.Set("per_page", strconv.Itoa(perPage))
+		pageQuery.Set("page", strconv.Itoa(page))
+
+		restResp, err := c.do(ctx, account, requestKind, path, pageQuery)
+		if err != nil {
+			return resultInfo{}, err
+		}
+		if err := handle(restResp.Result); err != nil {
+			return resultInfo{}, err
+		}
+		if page >= restResp.ResultInfo.TotalPages {
+			return restResp.ResultInfo, nil
+		}
+	}
+}
+
+func (c *restClient) do(
+	ctx context.Context, account *account, requestKind, path string, query url.Values,
+) (*restResponse, error) {
+	reqURL := c.baseURL + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := account.setAuthHeaders(req.Header); err != nil {
+		return nil, err
+	}
+
+	var restResp restResponse
+	duration, err := timeOperation(func() error {
+		return c.retryPolicy.do(ctx, c.logger, requestKind, func() error {
+			return observeAPIRequest(requestKind, account.name, "", func() error {
+				resp, err := c.httpClient.Do(req)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+
+				restResp = restResponse{}
+				decodeErr := json.NewDecoder(resp.Body).Decode(&restResp)
+				if resp.StatusCode != http.StatusOK {
+					// The error envelope is best-effort: an empty one still yields an
+					// error classified by status code.
+					apiErr := newRESTError(resp.StatusCode, restResp.Errors)
+					apiErr.retryAfter = parseRetryAfter(resp.Header, time.Now())
+					return apiErr
+				}
+				if decodeErr != nil {
+					return decodeErr
+				}
+				if !restResp.Success {
+					apiErr := newRESTError(resp.StatusCode, restResp.Errors)
+					if apiErr.message == "" {
+						apiErr.message = "unsuccessful response"
+					}
+					return apiErr
+				}
+				return nil
+			})
+		})
+	})
+	logger := log.With(c.logger, "request", requestKind, "account", account.name)
+	level.Debug(logger).Log("url", reqURL, "duration", duration.Seconds(), "msg", "finished request")
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range restResp.Messages {
+		level.Info(logger).Log("msg", "message from Cloudflare API", "code", message.Code, "message", message.Message)
+	}
+	return &restResp, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRESTClient_ClassifiesUnsuccessfulResponses(t *testing.T) {
+	for _, testCase := range []struct {
+		name         string
+		status       int
+		body         string
+		expectedKind errorKind
+		expectedErr  string
+	}{
+		{
+			name:         "error status with envelope",
+			status:       http.StatusForbidden,
+			body:         `{"success": false, "errors": [{"code": 9109, "message": "Unauthorized to access requested resource"}]}`,
+			expectedKind: errorKindAuthorization,
+			expectedErr:  "rest: Unauthorized to access requested resource (authorization)",
+		},
+		{
+			name:         "error status without envelope",
+			status:       http.StatusBadGateway,
+			body:         `<html>Bad gateway</html>`,
+			expectedKind: errorKindServer,
+			expectedErr:  "rest: unexpected status 502 (server_error)",
+		},
+		{
+			name:         "OK status but unsuccessful",
+			status:       http.StatusOK,
+			body:         `{"success": false, "errors": [{"code": 10000, "message": "Authentication error"}]}`,
+			expectedKind: errorKindAuthentication,
+			expectedErr:  "rest: Authentication error (authentication)",
+		},
+		{
+			name:         "OK status but unsuccessful without errors",
+			status:       http.StatusOK,
+			body:         `{"success": false, "errors": []}`,
+			expectedKind: errorKindUnknown,
+			expectedErr:  "rest: unsuccessful response (unknown)",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(testCase.status)
+				_, _ = w.Write([]byte(testCase.body))
+			}))
+			defer server.Close()
+			registerMetrics(prometheus.NewPedanticRegistry())
+
+			client := newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error"))
+			var result interface{}
+			_, err := client.get(context.Background(), &account{name: "an-account"}, "rest:test", "/test", nil, &result)
+			require.NotNil(t, err)
+			assert.Equal(t, testCase.expectedKind, errorKindOf(err))
+			assert.Equal(t, testCase.expectedErr, err.Error())
+		})
+	}
+}
+
+func TestRESTClient_GetPages(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.RawQuery)
+		fmt.Fprintf(w, `{"success": true, "result": ["item-%s"], "result_info": {"page": %[1]s, "total_pages": 3}}`,
+			r.URL.Query().Get("page"))
+	}))
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	client := newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error"))
+	var items []string
+	info, err := client.getPages(
+		context.Background(), &account{name: "an-account"}, "rest:test", "/test", url.Values{"status": {"active"}}, 10,
+		func(result json.RawMessage) error {
+			var pageItems []string
+			if err := json.Unmarshal(result, &pageItems); err != nil {
+				return err
+			}
+			items = append(items, pageItems...)
+			return nil
+		},
+	)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"item-1", "item-2", "item-3"}, items)
+	assert.Equal(t, 3, info.Page)
+	assert.Equal(t, []string{
+		"page=1&per_page=10&status=active", "page=2&per_page=10&status=active", "page=3&per_page=10&status=active",
+	}, requested)
+}
+
+func TestRESTClient_GetPagesStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"success": true, "result": [], "result_info": {"total_pages": 3}}`))
+	}))
+	defer server.Close()
+	registerMetrics(prometheus.NewPedanticRegistry())
+
+	client := newRESTClient(server.URL, http.DefaultClient, retryPolicy{}, newPromLogger("error"))
+	_, err := client.getPages(ctx, &account{name: "an-account"}, "rest:test", "/test", nil, 10, func(json.RawMessage) error {
+		cancel()
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, requests)
+}
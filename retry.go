@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// retryPolicy retries Cloudflare API requests that failed in a way that a
+// later attempt might not, such as server errors and connection resets. The
+// zero value makes a single attempt.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	// Fraction (0-1) by which each delay is randomly shortened, to avoid
+	// retrying in lockstep with other clients.
+	jitter float64
+}
+
+func (p retryPolicy) do(ctx context.Context, logger log.Logger, requestKind string, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.maxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := p.delay(attempt, err)
+		// Don't wait for a retry that cannot complete before the scrape times out.
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		level.Debug(logger).Log(
+			"msg", "retrying request", "request", requestKind, "attempt", attempt, "delay", delay, "error", err,
+		)
+		apiRequestRetries.WithLabelValues(requestKind).Inc()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (p retryPolicy) delay(attempt int, err error) time.Duration {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.retryAfter > 0 {
+		return apiErr.retryAfter
+	}
+
+	delay := p.maxDelay
+	// Guard against overflow for very large attempt counts.
+	if attempt < 32 && p.baseDelay<<(attempt-1) < p.maxDelay {
+		delay = p.baseDelay << (attempt - 1)
+	}
+	return time.Duration(float64(delay) * (1 - p.jitter*rand.Float64()))
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errQueryBudgetExhausted) {
+		return false
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		// Rate limited requests are only retried when the API tells us when to,
+		// otherwise we leave it to the scrape loop to back off.
+		return apiErr.kind == errorKindServer || (apiErr.kind == errorKindRateLimited && apiErr.retryAfter > 0)
+	}
+	// Anything else failed in transport, or returned an unreadable body.
+	return true
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		errs             []error
+		expectedAttempts int
+		expectedErr      bool
+	}{
+		{
+			name:             "does not retry success",
+			errs:             []error{nil},
+			expectedAttempts: 1,
+		},
+		{
+			name:             "retries server errors until success",
+			errs:             []error{&apiError{kind: errorKindServer}, errors.New("connection reset"), nil},
+			expectedAttempts: 3,
+		},
+		{
+			name: "gives up after max attempts",
+			errs: []error{
+				&apiError{kind: errorKindServer}, &apiError{kind: errorKindServer}, &apiError{kind: errorKindServer},
+				nil,
+			},
+			expectedAttempts: 3,
+			expectedErr:      true,
+		},
+		{
+			name:             "does not retry authentication errors",
+			errs:             []error{&apiError{kind: errorKindAuthentication}, nil},
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+		{
+			name:             "does not retry when the query budget is exhausted",
+			errs:             []error{errQueryBudgetExhausted, nil},
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+		{
+			name:             "does not retry rate limiting without Retry-After",
+			errs:             []error{&apiError{kind: errorKindRateLimited}, nil},
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+		{
+			name:             "retries rate limiting with Retry-After",
+			errs:             []error{&apiError{kind: errorKindRateLimited, retryAfter: time.Millisecond}, nil},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "does not retry when Retry-After exceeds the deadline",
+			errs:             []error{&apiError{kind: errorKindServer, retryAfter: time.Hour}, nil},
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registerMetrics(prometheus.NewPedanticRegistry())
+			policy := retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: 2 * time.Millisecond, jitter: 0.5}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			attempts := 0
+			err := policy.do(ctx, newPromLogger("error"), "a-request", func() error {
+				attempts++
+				return tc.errs[attempts-1]
+			})
+			assert.Equal(t, tc.expectedAttempts, attempts)
+			assert.Equal(t, tc.expectedErr, err != nil)
+			assert.Equal(t, float64(attempts-1), testutil.ToFloat64(apiRequestRetries.WithLabelValues("a-request")))
+		})
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := retryPolicy{baseDelay: time.Second, maxDelay: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.delay(1, errors.New("")))
+	assert.Equal(t, 4*time.Second, policy.delay(3, errors.New("")))
+	assert.Equal(t, 5*time.Second, policy.delay(4, errors.New("")))
+	assert.Equal(t, 5*time.Second, policy.delay(100, errors.New("")))
+	assert.Equal(t, time.Minute, policy.delay(1, &apiError{retryAfter: time.Minute}))
+
+	policy.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.delay(1, errors.New(""))
+		assert.True(t, delay > time.Second/2 && delay <= time.Second, delay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 5, 18, 10, 7, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"":                              0,
+		"30":                            30 * time.Second,
+		"not a delay":                   0,
+		"Mon, 18 May 2020 10:08:00 GMT": time.Minute,
+		"Mon, 18 May 2020 10:06:00 GMT": 0,
+	} {
+		header := http.Header{}
+		header.Set("Retry-After", value)
+		assert.Equal(t, expected, parseRetryAfter(header, now), value)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// analyticsDataset is a GraphQL analytics dataset, queried separately for each
+// member of its scope: each zone (or batch of zones, or combined with the other
+// zone datasets), or each Cloudflare account.
+type analyticsDataset struct {
+	// name is the field, or alias, of the dataset in scopeResp.
+	name        string
+	requestKind string
+	req         *graphqlRequest
+	selection   string
+	extract     extractFunc
+	// lastSeenBucketTimes is the time each member of the scope has been
+	// counted up to by the account, by zone or Cloudflare account tag.
+	lastSeenBucketTimes map[string]time.Time
+}
+
+// scope is what datasets are queried for: the zones, or the Cloudflare
+// accounts, that an account's credentials can access. Both are listed, queried
+// and forgotten alike, and differ only in their datasets and the metrics that
+// record them.
+type scope struct {
+	// kind names the scope in logs, and label in metrics.
+	kind, label string
+	datasets    func(*account) []analyticsDataset
+	active      *prometheus.GaugeVec
+	// changes, if not nil, counts members added and removed after the
+	// initial listing.
+	changes                     *prometheus.CounterVec
+	scrapeErrs                  *prometheus.CounterVec
+	lastSuccessTimestampSeconds *prometheus.GaugeVec
+	// datasetAvailable, if not nil, records the preflight checks of members.
+	datasetAvailable *prometheus.GaugeVec
+	metricVecs       []*TimestampedMetricVec
+}
+
+func (e *exporter) zoneScope() scope {
+	return scope{
+		kind: "zone", label: "zone", datasets: e.zoneDatasets, active: zonesActive, changes: zoneChanges,
+		scrapeErrs: zoneScrapeErrs, lastSuccessTimestampSeconds: zoneLastSuccessTimestampSeconds,
+		datasetAvailable: datasetAvailable, metricVecs: zoneMetricVecs(),
+	}
+}
+
+func (e *exporter) cloudflareAccountScope() scope {
+	return scope{
+		kind: "cloudflare account", label: "cloudflare_account", datasets: e.accountDatasets,
+		active: cloudflareAccountsActive, scrapeErrs: accountScrapeErrs,
+		lastSuccessTimestampSeconds: accountLastSuccessTimestampSeconds, metricVecs: accountMetricVecs(),
+	}
+}
+
+type datasetQuery struct {
+	dataset analyticsDataset
+	// tag is the ID of the zone or Cloudflare account to query.
+	tag string
+}
+
+// datasetQueries lists every dataset and member pair to query, those furthest
+// behind first, so that they are the first to be served when the query budget
+// runs short.
+func datasetQueries(datasets []analyticsDataset, members map[string]string) []datasetQuery {
+	var queries []datasetQuery
+	for _, dataset := range datasets {
+		for tag := range members {
+			queries = append(queries, datasetQuery{dataset: dataset, tag: tag})
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		iLastSeen := queries[i].dataset.lastSeenBucketTimes[queries[i].tag]
+		jLastSeen := queries[j].dataset.lastSeenBucketTimes[queries[j].tag]
+		if !iLastSeen.Equal(jLastSeen) {
+			return iLastSeen.Before(jLastSeen)
+		}
+		if queries[i].dataset.requestKind != queries[j].dataset.requestKind {
+			return queries[i].dataset.requestKind < queries[j].dataset.requestKind
+		}
+		return queries[i].tag < queries[j].tag
+	})
+	return queries
+}
+
+// recordScrape records the outcome of querying a dataset for a member of a
+// scope.
+func recordScrape(account *account, s scope, memberName string, dataset analyticsDataset, err error) {
+	if err != nil {
+		s.scrapeErrs.WithLabelValues(account.name, memberName, dataset.name, string(errorKindOf(err))).Inc()
+		return
+	}
+	s.lastSuccessTimestampSeconds.WithLabelValues(account.name, memberName, dataset.name).SetToCurrentTime()
+}
+
+// updateScope compares the members of a scope that an account's credentials
+// can now access with those it could before, seeding the start times of
+// members that are new, and forgetting the state and series of those that have
+// gone. The caller must hold the scrape lock, and replace the members.
+func (e *exporter) updateScope(account *account, s scope, members, updated map[string]string) {
+	initial := members == nil
+	start := time.Now().UTC().Add(-e.scrapeInterval)
+	for tag, name := range updated {
+		if _, ok := members[tag]; ok {
+			continue
+		}
+		if !initial {
+			level.Info(e.logger).Log("msg", s.kind+" added", "account", account.name, s.label, name)
+			if s.changes != nil {
+				s.changes.WithLabelValues(account.name, "added").Inc()
+			}
+		}
+		for _, dataset := range s.datasets(account) {
+			if _, ok := dataset.lastSeenBucketTimes[tag]; !ok {
+				dataset.lastSeenBucketTimes[tag] = start
+			}
+		}
+	}
+	for tag, name := range members {
+		if _, ok := updated[tag]; ok {
+			continue
+		}
+		level.Info(e.logger).Log("msg", s.kind+" removed", "account", account.name, s.label, name)
+		if s.changes != nil {
+			s.changes.WithLabelValues(account.name, "removed").Inc()
+		}
+		s.forget(account, tag, name)
+	}
+	s.active.WithLabelValues(account.name).Set(float64(len(updated)))
+}
+
+func (s scope) forget(account *account, tag, name string) {
+	for _, dataset := range s.datasets(account) {
+		delete(dataset.lastSeenBucketTimes, tag)
+		if s.datasetAvailable != nil {
+			s.datasetAvailable.DeleteLabelValues(account.name, name, dataset.name)
+		}
+		s.lastSuccessTimestampSeconds.DeleteLabelValues(account.name, name, dataset.name)
+		for _, kind := range errorKinds {
+			s.scrapeErrs.DeleteLabelValues(account.name, name, dataset.name, string(kind))
+		}
+	}
+	for _, metricVec := range s.metricVecs {
+		metricVec.DeletePartialMatch(prometheus.Labels{"account": account.name, s.label: name})
+	}
+}
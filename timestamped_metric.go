@@ -4,6 +4,7 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -79,19 +80,25 @@ func NewTimestampedMetricVec(
 		[]string{opts.Namespace, opts.Subsystem, opts.Name}, "_",
 	)
 	return &TimestampedMetricVec{
-		desc:      prometheus.NewDesc(fqName, opts.Help, variableLabels, opts.ConstLabels),
-		valueType: valueType,
-		metrics:   map[string]*TimestampedMetric{},
+		desc:       prometheus.NewDesc(fqName, opts.Help, variableLabels, opts.ConstLabels),
+		valueType:  valueType,
+		labelNames: variableLabels,
+		metrics:    map[string]*TimestampedMetric{},
 	}
 }
 
 type TimestampedMetricVec struct {
-	desc      *prometheus.Desc
-	valueType prometheus.ValueType
-	metrics   map[string]*TimestampedMetric
+	desc       *prometheus.Desc
+	valueType  prometheus.ValueType
+	labelNames []string
+
+	lock    sync.Mutex
+	metrics map[string]*TimestampedMetric
 }
 
 func (m *TimestampedMetricVec) WithLabelValues(labelValues ...string) *TimestampedMetric {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	labelHash := hashLabels(labelValues)
 	if m.metrics[labelHash] == nil {
 		metric := &TimestampedMetric{
@@ -109,11 +116,44 @@ func (m *TimestampedMetricVec) Describe(descs chan<- *prometheus.Desc) {
 }
 
 func (m *TimestampedMetricVec) Collect(metrics chan<- prometheus.Metric) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	for _, metric := range m.metrics {
 		metric.Collect(metrics)
 	}
 }
 
+// DeletePartialMatch deletes every metric whose labels include all of the
+// given ones, returning how many were deleted.
+func (m *TimestampedMetricVec) DeletePartialMatch(labels prometheus.Labels) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	deleted := 0
+	for labelHash, metric := range m.metrics {
+		if m.matches(metric, labels) {
+			delete(m.metrics, labelHash)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (m *TimestampedMetricVec) matches(metric *TimestampedMetric, labels prometheus.Labels) bool {
+	for name, value := range labels {
+		matched := false
+		for i, labelName := range m.labelNames {
+			if labelName == name && metric.labelValues[i] == value {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
 func hashLabels(labels []string) string {
 	hash := sha1.Sum([]byte(strings.Join(labels, "!!!")))
 	return hex.EncodeToString(hash[:])
@@ -61,6 +61,29 @@ func TestTimestampedMetric_countervec(t *testing.T) {
 	compareMetricsFixture(t, "countervec", vec)
 }
 
+func TestTimestampedMetric_countervecDeletePartialMatch(t *testing.T) {
+	vec := NewTimestampedMetricVec(
+		prometheus.CounterValue, prometheus.Opts{
+			Namespace:   "namespace",
+			Subsystem:   "subsystem",
+			Name:        "name",
+			Help:        "help",
+			ConstLabels: prometheus.Labels{"const": "constval"},
+		}, []string{"l1", "l2"},
+	)
+
+	vec.WithLabelValues("foo", "bar").Add(3, fixedTime.Add(time.Second*2))
+	vec.WithLabelValues("foo", "baz").Add(10, fixedTime.Add(time.Second*3))
+	vec.WithLabelValues("baz", "bar").Add(11, fixedTime.Add(time.Second*4))
+	vec.WithLabelValues("banana", "potato").Add(100, fixedTime.Add(time.Second*5))
+	vec.WithLabelValues("qux", "bar").Add(1, fixedTime.Add(time.Second*6))
+	vec.WithLabelValues("qux", "quux").Add(1, fixedTime.Add(time.Second*7))
+
+	require.Equal(t, 2, vec.DeletePartialMatch(prometheus.Labels{"l1": "qux"}))
+	require.Equal(t, 0, vec.DeletePartialMatch(prometheus.Labels{"l1": "foo", "l2": "potato"}))
+	compareMetricsFixture(t, "countervec", vec)
+}
+
 func compareMetricsFixture(t *testing.T, name string, metrics prometheus.Collector) {
 	fixture, err := os.Open(filepath.Join("testdata", "timestamped_metric_fixtures", name+".metrics"))
 	require.Nil(t, err)
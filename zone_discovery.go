@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// discoverZones lists every account's zones, and the Cloudflare accounts it can
+// access if any account-scoped datasets are scraped or load balancer health is
+// refreshed, at startup and then periodically. Zones and Cloudflare accounts
+// that are added or removed are picked up without listing them on every scrape.
+func (e *exporter) discoverZones(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, account := range e.accounts {
+			if err := e.refreshZones(ctx, account); err != nil && ctx.Err() == nil {
+				// The previously discovered zones are still scraped.
+				level.Error(e.logger).Log("msg", "listing zones failed", "account", account.name, "error", err)
+			}
+			e.scrapeLock.Lock()
+			scrapesAccountDatasets := len(e.accountDatasets(account)) > 0
+			e.scrapeLock.Unlock()
+			if !scrapesAccountDatasets && e.lbHealthInterval == 0 {
+				continue
+			}
+			if err := e.refreshCloudflareAccounts(ctx, account); err != nil && ctx.Err() == nil {
+				level.Error(e.logger).Log("msg", "listing cloudflare accounts failed", "account", account.name, "error", err)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (e *exporter) refreshZones(ctx context.Context, account *account) error {
+	ctx, cancel := context.WithTimeout(ctx, e.scrapeTimeout)
+	defer cancel()
+	zones, err := e.getZones(ctx, account)
+	if err != nil {
+		return err
+	}
+
+	e.scrapeLock.Lock()
+	defer e.scrapeLock.Unlock()
+	e.updateZones(account, zones)
+	return nil
+}
+
+// updateZones replaces an account's zones, as described by updateScope. The
+// caller must hold the scrape lock.
+func (e *exporter) updateZones(account *account, zones map[string]string) {
+	e.updateScope(account, e.zoneScope(), account.zones, zones)
+	account.zones = zones
+}
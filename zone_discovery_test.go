@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateZones(t *testing.T) {
+	registerMetrics(prometheus.NewPedanticRegistry())
+	cfExporter := exporter{
+		logger:         newPromLogger("error"),
+		scrapeInterval: time.Minute,
+	}
+	account := &account{name: "an-account"}
+
+	cfExporter.updateZones(account, map[string]string{"zone-1-id": "zone-1", "zone-2-id": "zone-2"})
+	assert.Equal(t, 2.0, testutil.ToFloat64(zonesActive.WithLabelValues("an-account")))
+	// The initial listing does not count as zones being added.
+	assert.Equal(t, 0.0, testutil.ToFloat64(zoneChanges.WithLabelValues("an-account", "added")))
+	for _, dataset := range cfExporter.zoneDatasets(account) {
+		require.Contains(t, dataset.lastSeenBucketTimes, "zone-2-id")
+		assert.WithinDuration(t, time.Now().Add(-time.Minute), dataset.lastSeenBucketTimes["zone-2-id"], 10*time.Second)
+	}
+
+	now := time.Now()
+	httpCachedRequests.WithLabelValues("an-account", "zone-1").Add(1, now)
+	httpCachedRequests.WithLabelValues("an-account", "zone-2").Add(1, now)
+	firewallEvents.WithLabelValues("an-account", "zone-2", "block", "waf", "a-rule", "403", "200").Add(1, now)
+	datasetAvailable.WithLabelValues("an-account", "zone-2", "httpRequests1mGroups").Set(1)
+
+	cfExporter.updateZones(account, map[string]string{"zone-1-id": "zone-1", "zone-3-id": "zone-3"})
+	assert.Equal(t, map[string]string{"zone-1-id": "zone-1", "zone-3-id": "zone-3"}, account.zones)
+	assert.Equal(t, 1.0, testutil.ToFloat64(zoneChanges.WithLabelValues("an-account", "added")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(zoneChanges.WithLabelValues("an-account", "removed")))
+	for _, dataset := range cfExporter.zoneDatasets(account) {
+		assert.NotContains(t, dataset.lastSeenBucketTimes, "zone-2-id")
+		assert.Contains(t, dataset.lastSeenBucketTimes, "zone-3-id")
+	}
+	assert.Equal(t, 1, testutil.CollectAndCount(httpCachedRequests))
+	assert.Equal(t, 0, testutil.CollectAndCount(firewallEvents))
+	assert.Equal(t, 0, testutil.CollectAndCount(datasetAvailable))
+}
+
+func TestUpdateZones_KeepsAccountsApart(t *testing.T) {
+	registerMetrics(prometheus.NewPedanticRegistry())
+	cfExporter := exporter{
+		logger:         newPromLogger("error"),
+		scrapeInterval: time.Minute,
+	}
+	// Both accounts' credentials can access the same zone.
+	aAccount := &account{name: "a-account"}
+	bAccount := &account{name: "b-account"}
+	cfExporter.updateZones(aAccount, map[string]string{"zone-1-id": "zone-1"})
+	cfExporter.updateZones(bAccount, map[string]string{"zone-1-id": "zone-1"})
+
+	aLastSeen := time.Date(2020, 2, 6, 10, 0, 0, 0, time.UTC)
+	bLastSeen := time.Date(2020, 2, 6, 11, 0, 0, 0, time.UTC)
+	aAccount.lastSeen("httpRequests1mGroups")["zone-1-id"] = aLastSeen
+	bAccount.lastSeen("httpRequests1mGroups")["zone-1-id"] = bLastSeen
+	assert.Equal(t, aLastSeen, aAccount.lastSeen("httpRequests1mGroups")["zone-1-id"])
+
+	cfExporter.updateZones(aAccount, map[string]string{})
+	assert.NotContains(t, aAccount.lastSeen("httpRequests1mGroups"), "zone-1-id")
+	assert.Equal(t, bLastSeen, bAccount.lastSeen("httpRequests1mGroups")["zone-1-id"])
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	zonePlans    = []string{"free", "pro", "business", "enterprise"}
+	zoneStatuses = []string{"active", "paused", "pending", "initializing", "moved", "deleted", "deactivated"}
+)
+
+// zoneFilter selects which of an account's zones to scrape. A zone must pass
+// every criterion that is set. Unless statuses are given, pending zones are
+// excluded, as they have no analytics.
+type zoneFilter struct {
+	// Zones are selected by name or ID if either is set.
+	names []string
+	ids   []string
+	// Regular expressions matched against zone names.
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	// Plans by legacy ID, e.g. "enterprise".
+	plans []string
+	// Statuses, where paused zones have the status "paused" rather than that
+	// reported by Cloudflare.
+	statuses []string
+}
+
+type zoneFilterConfig struct {
+	Names        []string `json:"zones"`
+	IDs          []string `json:"zone_ids"`
+	IncludeRegex string   `json:"zone_include_regex"`
+	ExcludeRegex string   `json:"zone_exclude_regex"`
+	Plans        []string `json:"zone_plans"`
+	Statuses     []string `json:"zone_statuses"`
+}
+
+func newZoneFilter(config zoneFilterConfig) (zoneFilter, error) {
+	filter := zoneFilter{names: config.Names, ids: config.IDs, plans: config.Plans, statuses: config.Statuses}
+	var err error
+	if config.IncludeRegex != "" {
+		if filter.include, err = regexp.Compile(config.IncludeRegex); err != nil {
+			return zoneFilter{}, fmt.Errorf("zone include regex: %w", err)
+		}
+	}
+	if config.ExcludeRegex != "" {
+		if filter.exclude, err = regexp.Compile(config.ExcludeRegex); err != nil {
+			return zoneFilter{}, fmt.Errorf("zone exclude regex: %w", err)
+		}
+	}
+	for _, plan := range config.Plans {
+		if !contains(zonePlans, plan) {
+			return zoneFilter{}, fmt.Errorf("unknown zone plan %q, expected one of %v", plan, zonePlans)
+		}
+	}
+	for _, status := range config.Statuses {
+		if !contains(zoneStatuses, status) {
+			return zoneFilter{}, fmt.Errorf("unknown zone status %q, expected one of %v", status, zoneStatuses)
+		}
+	}
+	return filter, nil
+}
+
+// isSet reports whether any criterion is configured.
+func (c zoneFilterConfig) isSet() bool {
+	return len(c.Names) > 0 || len(c.IDs) > 0 || c.IncludeRegex != "" || c.ExcludeRegex != "" ||
+		len(c.Plans) > 0 || len(c.Statuses) > 0
+}
+
+func (f zoneFilter) matches(zone zoneInfo) bool {
+	if (len(f.names) > 0 || len(f.ids) > 0) && !contains(f.names, zone.Name) && !contains(f.ids, zone.ID) {
+		return false
+	}
+	if f.include != nil && !f.include.MatchString(zone.Name) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.MatchString(zone.Name) {
+		return false
+	}
+	if len(f.plans) > 0 && !contains(f.plans, zone.Plan.LegacyID) {
+		return false
+	}
+
+	status := zone.Status
+	if zone.Paused {
+		status = "paused"
+	}
+	if len(f.statuses) == 0 {
+		return status != "pending"
+	}
+	return contains(f.statuses, status)
+}